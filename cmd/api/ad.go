@@ -1,20 +1,54 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
 	"antipinegor/cyclingmarket/internal/data"
+	"antipinegor/cyclingmarket/internal/i18n"
+	"antipinegor/cyclingmarket/internal/jsonpatch"
 	"antipinegor/cyclingmarket/internal/validator"
+
+	"github.com/tomasen/realip"
 )
 
+var errNoAdsPermission = errors.New("no permission to post ads for the organization")
+
+// adOrganizationID resolves the slug of the dealer ads are posted for. An empty slug is a private
+// seller and resolves to zero. The user needs the ads:write permission in the organization.
+func (app *application) adOrganizationID(r *http.Request, slug string) (int64, error) {
+	if slug == "" {
+		return 0, nil
+	}
+
+	org, err := app.models.Organizations.GetBySlug(r.Context(), slug)
+	if err != nil {
+		return 0, err
+	}
+
+	permitted, err := app.hasOrganizationPermission(r, org.ID, app.contextGetUser(r).ID, "ads:write")
+	if err != nil {
+		return 0, err
+	}
+	if !permitted {
+		return 0, errNoAdsPermission
+	}
+	return org.ID, nil
+}
+
 func (app *application) postAdHandler(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		Title       string     `json:"title"`
 		Description string     `json:"description"`
 		Categories  []string   `json:"categories"`
 		Price       data.Price `json:"price"`
+		Condition   string     `json:"condition"`
+		FrameSerial string     `json:"frame_serial"`
+		// Organization is the slug of the dealer the ad is posted for, empty for a private seller
+		Organization string `json:"organization"`
 	}
 
 	err := app.readJSON(w, r, &req)
@@ -22,21 +56,66 @@ func (app *application) postAdHandler(w http.ResponseWriter, r *http.Request) {
 		app.badRequestResponse(w, r, err)
 		return
 	}
+	if req.Condition == "" {
+		req.Condition = data.ConditionUsed
+	}
+
+	tree, err := app.models.Categories.GetTree(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
 
 	v := validator.New()
+	user := app.contextGetUser(r)
+
+	organizationID, err := app.adOrganizationID(r, req.Organization)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("organization", validator.NewMessage("unknown_organization"))
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, errNoAdsPermission):
+			app.notPermittedResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
 	ad := &data.Ad{
-		Title:       req.Title,
-		Description: req.Description,
-		Categories:  req.Categories,
-		Price:       req.Price,
+		Title:          req.Title,
+		Description:    req.Description,
+		Categories:     tree.Resolve(req.Categories),
+		Price:          req.Price,
+		Condition:      req.Condition,
+		FrameSerial:    data.NormalizeSerial(req.FrameSerial),
+		SellerID:       user.ID,
+		OrganizationID: organizationID,
+	}
+	data.ValidateAd(v, ad, tree)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.checkStolenSerial(r, v, ad.FrameSerial)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
 	}
-	data.ValidateAd(v, ad)
 	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	err = app.models.Ads.Insert(ad)
+	audit, err := app.newAuditEntry(r, data.AuditAdCreate, data.AuditEntityAd, 0, nil, ad)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Ads.Insert(r.Context(), ad, audit)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -44,6 +123,7 @@ func (app *application) postAdHandler(w http.ResponseWriter, r *http.Request) {
 
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/v1/ad/%d", ad.ID))
+	headers.Set("ETag", adETag(ad))
 	err = app.writeJSON(w, http.StatusCreated, envelope{"ad": ad}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -58,7 +138,14 @@ func (app *application) showAdHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ad, err := app.models.Ads.GetById(id)
+	v := validator.New()
+	fs := app.readFieldset(r.URL.Query(), v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ad, err := app.models.Ads.Get(r.Context(), id, fs.queryFields())
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -69,7 +156,25 @@ func (app *application) showAdHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"ad": ad}, nil)
+	err = app.embedAdIncludes(r, []*data.Ad{ad}, fs.Include)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	projected, err := fs.project(ad)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// the version only identifies the full representation, sparse ones are hashed
+	etag := adETag(ad)
+	if fs.sparse() {
+		etag = ""
+	}
+
+	err = app.writeCachedJSON(w, r, envelope{"ad": projected}, etag, ad.UpdatedAt)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -79,6 +184,7 @@ func (app *application) showAdsHandler(w http.ResponseWriter, r *http.Request) {
 	var response struct {
 		Title      string
 		Categories []string
+		Facets     []string
 		data.Filters
 	}
 
@@ -87,22 +193,84 @@ func (app *application) showAdsHandler(w http.ResponseWriter, r *http.Request) {
 	queryString := r.URL.Query()
 	response.Title = app.readString(queryString, "title", "")
 	response.Categories = app.readCSV(queryString, "categories", []string{})
+	response.Facets = app.readCSV(queryString, "facets", []string{})
+	fs := app.readFieldset(queryString, v)
 	response.Filters.Page = app.readInt(queryString, "page", 1, v)
 	response.Filters.PageSize = app.readInt(queryString, "page_size", 20, v)
 	response.Sort = app.readString(queryString, "sort", "id")
-	response.Filters.SortSafelist = []string{"id", "title", "price", "-id", "-title", "-price"}
+	response.Filters.SortSafelist = []string{"id", "title", "price", "relevance", "-id", "-title", "-price"}
+
+	if token := app.readString(queryString, "cursor", ""); token != "" {
+		cursor, err := data.DecodeCursor(token)
+		if err != nil {
+			v.AddError("cursor", validator.NewMessage("invalid_cursor"))
+		}
+		response.Filters.Cursor = cursor
+	}
+	// counting every matching row is what makes deep listings slow, cursor mode skips it unless asked
+	response.Filters.IncludeTotal = app.readBool(queryString, "include_total", response.Filters.Cursor == nil, v)
+
+	tree, err := app.models.Categories.GetTree(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	response.Categories = tree.Resolve(response.Categories)
+	data.ValidateCategories(v, "categories", response.Categories, tree)
+	v.Check(validator.PermittedValues(response.Facets, data.FacetSafelist...), "facets", validator.OnlyOf(data.FacetSafelist...))
+	v.Check(validator.Unique(response.Facets), "facets", validator.NoDuplicates)
 
 	if data.ValidateFilters(v, response.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	ads, metadata, err := app.models.Ads.GetAll(response.Title, response.Categories, response.Filters)
+	var ads []*data.Ad
+	var metadata data.Metadata
+	var facets data.Facets
+	if len(response.Facets) > 0 {
+		ads, metadata, facets, err = app.models.Ads.GetAllWithFacets(r.Context(), response.Title, response.Categories, fs.queryFields(), response.Filters, response.Facets)
+	} else {
+		ads, metadata, err = app.models.Ads.GetAll(r.Context(), response.Title, response.Categories, fs.queryFields(), response.Filters)
+	}
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if query := data.NormalizeQuery(response.Title); query != "" && response.Filters.Page == 1 && response.Filters.Cursor == nil && len(ads) > 0 {
+		logger := app.contextLogger(r)
+		searcher := "ip:" + realip.FromRequest(r)
+		if user := app.contextGetUser(r); !user.IsAnonymous() {
+			searcher = fmt.Sprintf("user:%d", user.ID)
+		}
+		ctx := context.WithoutCancel(r.Context())
+		app.background(func() {
+			err := app.models.Suggestions.LogQuery(ctx, query, searcher)
+			if err != nil {
+				logger.Error(err.Error())
+			}
+		})
+	}
+
+	err = app.embedAdIncludes(r, ads, fs.Include)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	projected, err := fs.project(ads)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	err = app.writeJSON(w, http.StatusOK, envelope{"ads": ads, "metadata": metadata}, nil)
+
+	env := envelope{"ads": projected, "metadata": metadata}
+
+	if len(response.Facets) > 0 {
+		env["facets"] = facets
+	}
+
+	err = app.writeCachedJSON(w, r, env, "", time.Time{})
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -115,7 +283,7 @@ func (app *application) updateAdHandler(w http.ResponseWriter, r *http.Request)
 		app.notFoundResponse(w, r)
 		return
 	}
-	ad, err := app.models.Ads.GetById(id)
+	ad, err := app.models.Ads.GetById(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -125,38 +293,91 @@ func (app *application) updateAdHandler(w http.ResponseWriter, r *http.Request)
 		}
 		return
 	}
-	var dataToUpdate struct {
-		Title       *string     `json:"title"`
-		Description *string     `json:"description"`
-		Categories  []string    `json:"categories"`
-		Price       *data.Price `json:"price"`
-	}
-	err = app.readJSON(w, r, &dataToUpdate)
+
+	permitted, err := app.canManageAd(r, ad)
 	if err != nil {
-		app.badRequestResponse(w, r, err)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !permitted {
+		app.notPermittedResponse(w, r)
 		return
 	}
 
-	if dataToUpdate.Title != nil {
-		ad.Title = *dataToUpdate.Title
+	if !app.checkIfMatch(r, ad) {
+		app.preconditionFailedResponse(w, r)
+		return
 	}
-	if dataToUpdate.Description != nil {
-		ad.Description = *dataToUpdate.Description
+
+	var patched data.Ad
+	err = app.readPatch(w, r, ad, &patched, data.AdReadOnlyFields)
+	if err != nil {
+		var readOnlyErr *readOnlyFieldsError
+		switch {
+		case errors.As(err, &readOnlyErr):
+			app.failedValidationResponse(w, r, readOnlyErr.validationErrors())
+		case errors.Is(err, errUnsupportedPatchType):
+			app.unsupportedPatchTypeResponse(w, r)
+		case errors.Is(err, jsonpatch.ErrTestFailed):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, jsonpatch.ErrInapplicablePatch):
+			app.invalidPatchResponse(w, r, err)
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
 	}
-	if dataToUpdate.Categories != nil {
-		ad.Categories = dataToUpdate.Categories
+
+	// the client edited the version it sent (or the one in If-Match, checked above), Update only
+	// succeeds if the stored ad still has this version, so concurrent edits can't be overwritten
+	if patched.Version != ad.Version {
+		app.editConflictResponse(w, r)
+		return
 	}
-	if dataToUpdate.Price != nil {
-		ad.Price = *dataToUpdate.Price
+
+	tree, err := app.models.Categories.GetTree(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
 	}
 
+	original := ad
+
+	patched.CreatedAt = ad.CreatedAt
+	patched.UpdatedAt = ad.UpdatedAt
+	patched.SellerID = ad.SellerID
+	patched.OrganizationID = ad.OrganizationID
+	patched.ExpiresAt = ad.ExpiresAt
+	patched.Snippet = ""
+	patched.Seller = nil
+	patched.Images = nil
+	patched.Categories = tree.Resolve(patched.Categories)
+	patched.FrameSerial = data.NormalizeSerial(patched.FrameSerial)
+	ad = &patched
+
 	v := validator.New()
-	if data.ValidateAd(v, ad); !v.Valid() {
+	if data.ValidateAd(v, ad, tree); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.checkStolenSerial(r, v, ad.FrameSerial)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	err = app.models.Ads.Update(ad)
+	audit, err := app.newAuditEntry(r, data.AuditAdUpdate, data.AuditEntityAd, ad.ID, original, ad)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Ads.Update(r.Context(), ad, audit, app.priceDropMessage(r, ad))
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -167,7 +388,9 @@ func (app *application) updateAdHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"ad": ad}, nil)
+	headers := make(http.Header)
+	headers.Set("ETag", adETag(ad))
+	err = app.writeJSON(w, http.StatusOK, envelope{"ad": ad}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -180,7 +403,39 @@ func (app *application) deleteAdHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = app.models.Ads.Delete(id)
+	ad, err := app.models.Ads.GetById(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	permitted, err := app.canManageAd(r, ad)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !permitted {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	if !app.checkIfMatch(r, ad) {
+		app.preconditionFailedResponse(w, r)
+		return
+	}
+
+	audit, err := app.newAuditEntry(r, data.AuditAdDelete, data.AuditEntityAd, ad.ID, ad, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Ads.Delete(r.Context(), id, audit)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -192,9 +447,63 @@ func (app *application) deleteAdHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "Ad successfully deleted"}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": i18n.Text(app.locale(r), "ad_deleted")}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 
 }
+
+// restoreAdHandler brings back an ad its owner deleted, within data.AdRestoreWindow.
+func (app *application) restoreAdHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	ad, err := app.models.Ads.GetDeleted(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	permitted, err := app.canManageAd(r, ad)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !permitted {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	audit, err := app.newAuditEntry(r, data.AuditAdRestore, data.AuditEntityAd, ad.ID, envelope{"deleted": true}, envelope{"deleted": false})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Ads.Restore(r.Context(), ad, audit)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", adETag(ad))
+	err = app.writeJSON(w, http.StatusOK, envelope{"ad": ad}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"antipinegor/cyclingmarket/internal/data"
+	"antipinegor/cyclingmarket/internal/validator"
+	"net/http"
+
+	"github.com/tomasen/realip"
+)
+
+// newAuditEntry starts an audit log entry for a change made by the request, the model that
+// applies the change writes it in the same transaction.
+func (app *application) newAuditEntry(r *http.Request, action, entity string, entityID int64, before, after any) (*data.AuditEntry, error) {
+	entry := &data.AuditEntry{
+		ActorID:   app.contextGetUser(r).ID,
+		IP:        realip.FromRequest(r),
+		Action:    action,
+		Entity:    entity,
+		EntityID:  entityID,
+		RequestID: app.requestID(r),
+	}
+
+	err := entry.Track(before, after)
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func (app *application) listAuditLogHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	queryString := r.URL.Query()
+
+	filter := data.AuditFilter{
+		ActorID:  int64(app.readInt(queryString, "actor_id", 0, v)),
+		Entity:   app.readString(queryString, "entity", ""),
+		EntityID: int64(app.readInt(queryString, "entity_id", 0, v)),
+		From:     app.readTime(queryString, "from", v),
+		To:       app.readTime(queryString, "to", v),
+	}
+	filters := data.Filters{
+		Page:         app.readInt(queryString, "page", 1, v),
+		PageSize:     app.readInt(queryString, "page_size", 20, v),
+		Sort:         "-created_at",
+		SortSafelist: []string{"-created_at"},
+	}
+
+	if filter.Entity != "" {
+		v.Check(validator.PermittedValue(filter.Entity, data.AuditEntities...), "entity", validator.OneOf(data.AuditEntities...))
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() {
+		v.Check(filter.From.Before(filter.To), "to", validator.NewMessage("after_from"))
+	}
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	entries, metadata, err := app.models.Audit.GetAll(r.Context(), filter, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"entries": entries, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
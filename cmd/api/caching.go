@@ -0,0 +1,102 @@
+package main
+
+import (
+	"antipinegor/cyclingmarket/internal/data"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+func adETag(ad *data.Ad) string {
+	return fmt.Sprintf(`"ad-%d-v%d"`, ad.ID, ad.Version)
+}
+
+// etagListed reports whether an If-Match or If-None-Match header value lists the etag.
+// If-None-Match uses the weak comparison, so a W/ prefix added by a proxy is ignored.
+func etagListed(header, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIfMatch enforces the If-Match precondition of a write request against the current ad,
+// it returns false when the client has edited a stale representation.
+func (app *application) checkIfMatch(r *http.Request, ad *data.Ad) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return true
+	}
+	return etagListed(header, adETag(ad), false)
+}
+
+// cacheControl lets shared caches store responses to anonymous requests for a minute. Responses
+// to authenticated users are only revalidated by their own client.
+func (app *application) cacheControl(r *http.Request) string {
+	if app.contextGetUser(r).IsAnonymous() {
+		return "public, max-age=60"
+	}
+	return "private, no-cache"
+}
+
+// writeCachedJSON works like writeJSON for responses to GET requests, but answers with
+// 304 Not Modified when the client already has the current representation. An empty etag
+// is replaced by a hash of the body, a zero lastModified omits the Last-Modified header.
+func (app *application) writeCachedJSON(w http.ResponseWriter, r *http.Request, data envelope, etag string, lastModified time.Time) error {
+	js, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	js = append(js, '\n')
+
+	if etag == "" {
+		sum := sha256.Sum256(js)
+		etag = `"` + hex.EncodeToString(sum[:16]) + `"`
+	}
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", app.cacheControl(r))
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	if notModified(r, etag, lastModified) {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(js)
+
+	return nil
+}
+
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if header := r.Header.Get("If-None-Match"); header != "" {
+		return etagListed(header, etag, true)
+	}
+
+	if header := r.Header.Get("If-Modified-Since"); header != "" && !lastModified.IsZero() {
+		since, err := http.ParseTime(header)
+		if err != nil {
+			return false
+		}
+		return !lastModified.Truncate(time.Second).After(since)
+	}
+
+	return false
+}
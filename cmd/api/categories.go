@@ -0,0 +1,18 @@
+package main
+
+import (
+	"net/http"
+)
+
+func (app *application) listCategoriesHandler(w http.ResponseWriter, r *http.Request) {
+	tree, err := app.models.Categories.GetTree(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"categories": tree.Roots}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"antipinegor/cyclingmarket/internal/data"
 	"context"
+	"log/slog"
 	"net/http"
 )
 
@@ -11,6 +12,9 @@ type contextKey string
 const userContextKey = contextKey("user")
 
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
+	if info, ok := r.Context().Value(requestInfoContextKey).(*requestInfo); ok {
+		info.userID = user.ID
+	}
 	ctx := context.WithValue(r.Context(), userContextKey, user)
 	return r.WithContext(ctx)
 }
@@ -22,3 +26,62 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 	}
 	return user
 }
+
+const organizationContextKey = contextKey("organization")
+
+func (app *application) contextSetOrganization(r *http.Request, org *data.Organization) *http.Request {
+	ctx := context.WithValue(r.Context(), organizationContextKey, org)
+	return r.WithContext(ctx)
+}
+
+func (app *application) contextGetOrganization(r *http.Request) *data.Organization {
+	org, ok := r.Context().Value(organizationContextKey).(*data.Organization)
+	if !ok {
+		panic("missing organization value in request context")
+	}
+	return org
+}
+
+// requestInfo is shared by every handler of a request. It is a pointer, so that logRequests
+// sees the user that authenticate finds further down the chain.
+type requestInfo struct {
+	id     string
+	logger *slog.Logger
+	userID int64
+	route  string
+}
+
+const requestInfoContextKey = contextKey("request_info")
+
+func (app *application) contextSetRequestInfo(r *http.Request, info *requestInfo) *http.Request {
+	ctx := context.WithValue(r.Context(), requestInfoContextKey, info)
+	return r.WithContext(ctx)
+}
+
+// withRoute records the pattern of the route that matched the request, for logs and metrics.
+func (app *application) withRoute(pattern string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if info, ok := r.Context().Value(requestInfoContextKey).(*requestInfo); ok {
+			info.route = pattern
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// requestID returns the ID logRequests gave the request.
+func (app *application) requestID(r *http.Request) string {
+	info, ok := r.Context().Value(requestInfoContextKey).(*requestInfo)
+	if !ok {
+		return ""
+	}
+	return info.id
+}
+
+// contextLogger returns the logger of the request, its records carry the request ID.
+func (app *application) contextLogger(r *http.Request) *slog.Logger {
+	info, ok := r.Context().Value(requestInfoContextKey).(*requestInfo)
+	if !ok {
+		return app.logger
+	}
+	return info.logger
+}
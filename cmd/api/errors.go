@@ -1,77 +1,287 @@
 package main
 
 import (
-	"fmt"
+	"antipinegor/cyclingmarket/internal/i18n"
+	"antipinegor/cyclingmarket/internal/validator"
+	"encoding/json"
+	"errors"
+	"mime"
 	"net/http"
+	"slices"
+	"strings"
 )
 
+const problemContentType = "application/problem+json"
+
+// apiError is what the error helpers report. Code is stable and the same in every language,
+// Message is localized for the client; Detail carries the untranslated cause if there is one.
+type apiError struct {
+	Code    string
+	Message string
+	Detail  string
+	Fields  map[string]fieldError
+}
+
+type fieldError struct {
+	Code    string
+	Message string
+}
+
+// requestError is a bad request with a catalog code, its detail is localized like the message.
+// Error returns the English text for the logs.
+type requestError struct {
+	code string
+	args []any
+}
+
+func newRequestError(code string, args ...any) error {
+	return &requestError{code: code, args: args}
+}
+
+func (e *requestError) Error() string {
+	return i18n.Text(i18n.DefaultLocale, e.code, e.args...)
+}
+
+// legacyBody is the value of the "error" key of the plain error envelope: the detail or the
+// message as a string, or the messages of the invalid fields keyed by field name.
+func (apiErr apiError) legacyBody() any {
+	if len(apiErr.Fields) > 0 {
+		messages := make(map[string]string, len(apiErr.Fields))
+		for field, fieldErr := range apiErr.Fields {
+			messages[field] = fieldErr.Message
+		}
+		return messages
+	}
+	if apiErr.Detail != "" {
+		return apiErr.Detail
+	}
+	return apiErr.Message
+}
+
+// legacyEnvelope is the plain error envelope. The codes are sent next to "error", so that clients
+// can match on them without asking for problem details: "code" for the error and "error_codes"
+// for the invalid fields.
+func (apiErr apiError) legacyEnvelope() envelope {
+	env := envelope{"error": apiErr.legacyBody(), "code": apiErr.Code}
+	if len(apiErr.Fields) > 0 {
+		codes := make(map[string]string, len(apiErr.Fields))
+		for field, fieldErr := range apiErr.Fields {
+			codes[field] = fieldErr.Code
+		}
+		env["error_codes"] = codes
+	}
+	return env
+}
+
+// problem is an RFC 9457 problem details document, sent instead of the error envelope to
+// clients that accept application/problem+json. Code and Errors are extension members.
+type problem struct {
+	Type      string         `json:"type"`
+	Title     string         `json:"title"`
+	Status    int            `json:"status"`
+	Detail    string         `json:"detail,omitempty"`
+	Instance  string         `json:"instance"`
+	Code      string         `json:"code"`
+	RequestID string         `json:"request_id,omitempty"`
+	Errors    []problemField `json:"errors,omitempty"`
+}
+
+type problemField struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// wantsProblem reports whether the Accept header lists application/problem+json. Only then are
+// the error codes sent, the plain error envelope stays the default so that existing clients
+// keep working.
+func wantsProblem(r *http.Request) bool {
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err == nil && mediaType == problemContentType {
+			return true
+		}
+	}
+	return false
+}
+
+func (app *application) newProblem(r *http.Request, status int, apiErr apiError) problem {
+	p := problem{
+		Type:      "urn:cyclingmarket:problem:" + apiErr.Code,
+		Title:     apiErr.Message,
+		Status:    status,
+		Detail:    apiErr.Detail,
+		Instance:  r.URL.RequestURI(),
+		Code:      apiErr.Code,
+		RequestID: app.requestID(r),
+	}
+
+	for field, fieldErr := range apiErr.Fields {
+		p.Errors = append(p.Errors, problemField{Field: field, Code: fieldErr.Code, Message: fieldErr.Message})
+	}
+	slices.SortFunc(p.Errors, func(a, b problemField) int {
+		return strings.Compare(a.Field, b.Field)
+	})
+
+	return p
+}
+
+// locale is the language the client asked for in the Accept-Language header.
+func (app *application) locale(r *http.Request) string {
+	return i18n.Negotiate(r.Header.Get("Accept-Language"))
+}
+
+// localizeErrors renders validation errors as plain texts in the locale.
+func localizeErrors(locale string, errors map[string]validator.Message) map[string]string {
+	texts := make(map[string]string, len(errors))
+	for key, message := range errors {
+		texts[key] = i18n.Text(locale, message.Code, message.Args...)
+	}
+	return texts
+}
+
 func (app *application) logError(r *http.Request, err error) {
-	app.logger.Error(err.Error(), "method", r.Method, "uri", r.URL.RequestURI())
+	app.contextLogger(r).Error(err.Error(), "method", r.Method, "uri", r.URL.RequestURI())
 }
 
-func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, msg any) {
-	err := app.writeJSON(w, status, envelope{"error": msg}, nil)
+// errorResponse is what every error helper ends in: the plain error envelope with localized
+// messages by default or a problem details document with codes if the client asked for one.
+func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, apiErr apiError) {
+	w.Header().Set("Content-Language", app.locale(r))
+	w.Header().Add("Vary", "Accept-Language")
+	w.Header().Add("Vary", "Accept")
+
+	if !wantsProblem(r) {
+		err := app.writeJSON(w, status, apiErr.legacyEnvelope(), nil)
+		if err != nil {
+			app.logError(r, err)
+			w.WriteHeader(500)
+		}
+		return
+	}
+
+	js, err := json.Marshal(app.newProblem(r, status, apiErr))
 	if err != nil {
 		app.logError(r, err)
 		w.WriteHeader(500)
+		return
 	}
+
+	w.Header().Set("Content-Type", problemContentType)
+	w.WriteHeader(status)
+	w.Write(append(js, '\n'))
+}
+
+// codeResponse sends the error with the code and its message in the locale of the client.
+func (app *application) codeResponse(w http.ResponseWriter, r *http.Request, status int, code string, args ...any) {
+	app.errorResponse(w, r, status, apiError{Code: code, Message: i18n.Text(app.locale(r), code, args...)})
 }
 
 func (app *application) serverErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
 	app.logError(r, err)
-	msg := "the server encountered a problem and could not process your request"
-	app.errorResponse(w, r, http.StatusInternalServerError, msg)
+	app.codeResponse(w, r, http.StatusInternalServerError, "server_error")
 }
 
 func (app *application) notFoundResponse(w http.ResponseWriter, r *http.Request) {
-	msg := "the requested resource could not be found"
-	app.errorResponse(w, r, http.StatusNotFound, msg)
+	app.codeResponse(w, r, http.StatusNotFound, "not_found")
 }
 
 func (app *application) methodNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
-	msg := fmt.Sprintf("the %s method is not supported for this resource", r.Method)
-	app.errorResponse(w, r, http.StatusMethodNotAllowed, msg)
+	app.codeResponse(w, r, http.StatusMethodNotAllowed, "method_not_allowed", r.Method)
 }
 
 func (app *application) badRequestResponse(w http.ResponseWriter, r *http.Request, err error) {
-	app.errorResponse(w, r, http.StatusBadRequest, err.Error())
+	locale := app.locale(r)
+
+	detail := err.Error()
+	var reqErr *requestError
+	if errors.As(err, &reqErr) {
+		detail = i18n.Text(locale, reqErr.code, reqErr.args...)
+	}
+
+	app.errorResponse(w, r, http.StatusBadRequest, apiError{
+		Code:    "bad_request",
+		Message: i18n.Text(locale, "bad_request"),
+		Detail:  detail,
+	})
 }
 
-func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
-	app.errorResponse(w, r, http.StatusUnprocessableEntity, errors)
+func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]validator.Message) {
+	locale := app.locale(r)
+
+	fields := make(map[string]fieldError, len(errors))
+	for key, message := range errors {
+		fields[key] = fieldError{Code: message.Code, Message: i18n.Text(locale, message.Code, message.Args...)}
+	}
+
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, apiError{
+		Code:    "failed_validation",
+		Message: i18n.Text(locale, "failed_validation"),
+		Fields:  fields,
+	})
 }
 
 func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
-	msg := "invalid authentication credentials"
-	app.errorResponse(w, r, http.StatusUnauthorized, msg)
+	app.codeResponse(w, r, http.StatusUnauthorized, "invalid_credentials")
 }
 
 func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("WWW-Authenticate", "Bearer")
-	msg := "invalid or missing authenication token"
-	app.errorResponse(w, r, http.StatusUnauthorized, msg)
+	app.codeResponse(w, r, http.StatusUnauthorized, "invalid_token")
 }
 
 func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
-	msg := "you must be authenticated to access this resource"
-	app.errorResponse(w, r, http.StatusUnauthorized, msg)
+	app.codeResponse(w, r, http.StatusUnauthorized, "authentication_required")
 }
 func (app *application) inactiveAccountResponse(w http.ResponseWriter, r *http.Request) {
-	msg := "your user account must be activated to access this resource"
-	app.errorResponse(w, r, http.StatusForbidden, msg)
+	app.codeResponse(w, r, http.StatusForbidden, "inactive_account")
 }
 
 func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
-	message := "your user account doesn't have the necessary permissions to access this resource"
-	app.errorResponse(w, r, http.StatusForbidden, message)
+	app.codeResponse(w, r, http.StatusForbidden, "not_permitted")
 }
 
 func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Request) {
-	msg := "unable to update the record due to an edit conflict, someone has already changed this ad, please try again"
-	app.errorResponse(w, r, http.StatusConflict, msg)
+	app.codeResponse(w, r, http.StatusConflict, "edit_conflict")
+}
+
+func (app *application) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	app.codeResponse(w, r, http.StatusPreconditionFailed, "precondition_failed")
+}
+
+func (app *application) unsupportedPatchTypeResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Accept-Patch", "application/merge-patch+json, application/json-patch+json")
+	app.codeResponse(w, r, http.StatusUnsupportedMediaType, "unsupported_patch_type")
+}
+
+func (app *application) unsupportedImportTypeResponse(w http.ResponseWriter, r *http.Request) {
+	app.codeResponse(w, r, http.StatusUnsupportedMediaType, "unsupported_import_type")
+}
+
+func (app *application) invalidPatchResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, apiError{
+		Code:    "invalid_patch",
+		Message: i18n.Text(app.locale(r), "invalid_patch"),
+		Detail:  err.Error(),
+	})
+}
+
+func (app *application) idempotencyConflictResponse(w http.ResponseWriter, r *http.Request) {
+	app.codeResponse(w, r, http.StatusConflict, "idempotency_conflict")
+}
+
+func (app *application) idempotencyKeyReusedResponse(w http.ResponseWriter, r *http.Request) {
+	app.codeResponse(w, r, http.StatusUnprocessableEntity, "idempotency_key_reused")
+}
+
+func (app *application) lastOwnerResponse(w http.ResponseWriter, r *http.Request) {
+	app.codeResponse(w, r, http.StatusConflict, "last_owner")
+}
+
+func (app *application) outboxMessageDeadResponse(w http.ResponseWriter, r *http.Request) {
+	app.codeResponse(w, r, http.StatusConflict, "outbox_message_dead")
 }
 
 func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
-	msg := "rate limit exceeded"
-	app.errorResponse(w, r, http.StatusTooManyRequests, msg)
+	app.codeResponse(w, r, http.StatusTooManyRequests, "rate_limit_exceeded")
 }
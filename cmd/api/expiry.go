@@ -0,0 +1,90 @@
+package main
+
+import (
+	"antipinegor/cyclingmarket/internal/data"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// appURL returns the link to a page of the web app, the path is formatted with args.
+func (app *application) appURL(path string, args ...any) string {
+	return strings.TrimSuffix(app.config.appURL, "/") + fmt.Sprintf(path, args...)
+}
+
+// sendRenewalReminders emails the owners of ads that are about to expire, it runs as a periodic job.
+func (app *application) sendRenewalReminders(ctx context.Context) error {
+	lifetimeDays := int(data.AdLifetime.Hours() / 24)
+
+	sent, err := app.models.Ads.RemindExpiring(ctx, func(reminder *data.RenewalReminder) *data.OutboxMessage {
+		variables := map[string]any{
+			"adID":         reminder.AdID,
+			"renewURL":     app.appURL("/ads/%d/renew", reminder.AdID),
+			"title":        reminder.Title,
+			"username":     reminder.Name,
+			"expiresAt":    reminder.ExpiresAt.Format("02.01.2006"),
+			"lifetimeDays": lifetimeDays,
+		}
+
+		return &data.OutboxMessage{Recipient: reminder.Email, Locale: reminder.Locale, Template: "ad_renewal.tmpl", Data: variables}
+	})
+	if sent > 0 {
+		app.logger.Info("renewal reminders queued", "count", sent)
+	}
+	return err
+}
+
+func (app *application) renewAdHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	ad, err := app.models.Ads.GetById(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	permitted, err := app.canManageAd(r, ad)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !permitted {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	audit, err := app.newAuditEntry(r, data.AuditAdRenew, data.AuditEntityAd, ad.ID, ad, ad)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Ads.Renew(r.Context(), ad, audit)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", adETag(ad))
+	err = app.writeJSON(w, http.StatusOK, envelope{"ad": ad}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"antipinegor/cyclingmarket/internal/data"
+	"antipinegor/cyclingmarket/internal/i18n"
+	"antipinegor/cyclingmarket/internal/validator"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+func (app *application) showPriceHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.models.Ads.GetById(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	history, err := app.models.Ads.GetPriceHistory(r.Context(), id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"price_history": history}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listFavoritesHandler(w http.ResponseWriter, r *http.Request) {
+	favorites, err := app.models.Favorites.GetAllForUser(r.Context(), app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"favorites": favorites}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// putFavoriteHandler adds an ad to the favorites of the user, for an ad that is already
+// a favorite it only changes the price drop threshold.
+func (app *application) putFavoriteHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var req struct {
+		PriceDropThreshold *int `json:"price_drop_threshold"`
+	}
+
+	err = app.readJSON(w, r, &req)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	threshold := data.DefaultPriceDropThreshold
+	if req.PriceDropThreshold != nil {
+		threshold = *req.PriceDropThreshold
+	}
+
+	v := validator.New()
+	if data.ValidatePriceDropThreshold(v, threshold); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	favorite, err := app.models.Favorites.Upsert(r.Context(), app.contextGetUser(r).ID, id, threshold)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"favorite": favorite}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteFavoriteHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Favorites.Delete(r.Context(), app.contextGetUser(r).ID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": i18n.Text(app.locale(r), "favorite_removed")}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// priceDropMessage builds the email to a user who favorited the ad and whose threshold its new price reaches.
+func (app *application) priceDropMessage(r *http.Request, ad *data.Ad) func(*data.PriceDropWatcher) *data.OutboxMessage {
+	requestID := app.requestID(r)
+
+	return func(watcher *data.PriceDropWatcher) *data.OutboxMessage {
+		variables := map[string]any{
+			"adID":     ad.ID,
+			"adURL":    app.appURL("/ads/%d", ad.ID),
+			"title":    ad.Title,
+			"username": watcher.Name,
+			"oldPrice": fmt.Sprintf("%d $", watcher.ReferencePrice),
+			"newPrice": fmt.Sprintf("%d $", ad.Price),
+			"percent":  watcher.DropPercent(ad.Price),
+		}
+
+		return &data.OutboxMessage{Recipient: watcher.Email, Locale: watcher.Locale, Template: "price_drop.tmpl", Data: variables, RequestID: requestID}
+	}
+}
@@ -1,15 +1,20 @@
 package main
 
 import (
+	"antipinegor/cyclingmarket/internal/jsonpatch"
 	"antipinegor/cyclingmarket/internal/validator"
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"net/url"
+	"reflect"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 )
@@ -65,16 +70,46 @@ func (app *application) readInt(queryString url.Values, key string, defaultValue
 	}
 	i, err := strconv.Atoi(str)
 	if err != nil {
-		v.AddError(key, "must be an integer value")
+		v.AddError(key, validator.NotInteger)
 		return defaultValue
 	}
 	return i
 }
 
-func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any) error {
+func (app *application) readBool(queryString url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+	str := queryString.Get(key)
+	if str == "" {
+		return defaultValue
+	}
+	b, err := strconv.ParseBool(str)
+	if err != nil {
+		v.AddError(key, validator.NotBoolean)
+		return defaultValue
+	}
+	return b
+}
 
+// readTime reads an RFC 3339 timestamp, a missing value is the zero time.
+func (app *application) readTime(queryString url.Values, key string, v *validator.Validator) time.Time {
+	str := queryString.Get(key)
+	if str == "" {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339, str)
+	if err != nil {
+		v.AddError(key, validator.NotTimestamp)
+		return time.Time{}
+	}
+	return t
+}
+
+func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any) error {
 	r.Body = http.MaxBytesReader(w, r.Body, 1_048_576)
-	decoder := json.NewDecoder(r.Body)
+	return app.decodeJSON(r.Body, dst)
+}
+
+func (app *application) decodeJSON(body io.Reader, dst any) error {
+	decoder := json.NewDecoder(body)
 	decoder.DisallowUnknownFields()
 
 	err := decoder.Decode(dst)
@@ -87,26 +122,26 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any
 		switch {
 
 		case errors.As(err, &syntaxError):
-			return fmt.Errorf("body contains badly-formed json (at character %d)", syntaxError.Offset)
+			return newRequestError("body_malformed_json_at", syntaxError.Offset)
 
 		case errors.Is(err, io.ErrUnexpectedEOF):
-			return errors.New("body contains badly-formed JSON")
+			return newRequestError("body_malformed_json")
 
 		case errors.As(err, &unmarshalTypeError):
 			if unmarshalTypeError.Field != "" {
-				return fmt.Errorf("body contains incorrect JSON type for field %q", unmarshalTypeError.Field)
+				return newRequestError("body_wrong_type_field", unmarshalTypeError.Field)
 			}
-			return fmt.Errorf("body contains incorrect JSON type (at character %d)", unmarshalTypeError.Offset)
+			return newRequestError("body_wrong_type_at", unmarshalTypeError.Offset)
 
 		case errors.Is(err, io.EOF):
-			return errors.New("body must not be empty")
+			return newRequestError("body_empty")
 
 		case strings.HasPrefix(err.Error(), "json: unknown field "):
 			fieldName := strings.TrimPrefix(err.Error(), "json: unknown field ")
-			return fmt.Errorf("body contains unknown key %s", fieldName)
+			return newRequestError("body_unknown_key", fieldName)
 
 		case errors.As(err, &maxBytesError):
-			return fmt.Errorf("body must not be longer then %d bytes", maxBytesError.Limit)
+			return newRequestError("body_too_large", maxBytesError.Limit)
 
 		case errors.As(err, &invalidUnmarshalError):
 			panic(err)
@@ -118,12 +153,104 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any
 
 	err = decoder.Decode(&struct{}{})
 	if !errors.Is(err, io.EOF) {
-		return errors.New("body must only contain a single json value")
+		return newRequestError("body_multiple_values")
 	}
 
 	return nil
 }
 
+var errUnsupportedPatchType = errors.New("unsupported patch content type")
+
+// readOnlyFieldsError lists the read only fields a patch changed.
+type readOnlyFieldsError struct {
+	fields []string
+}
+
+func (e *readOnlyFieldsError) Error() string {
+	return fmt.Sprintf("patch changes read only fields %s", strings.Join(e.fields, ", "))
+}
+
+func (e *readOnlyFieldsError) validationErrors() map[string]validator.Message {
+	errors := make(map[string]validator.Message, len(e.fields))
+	for _, field := range e.fields {
+		errors[field] = validator.ReadOnly
+	}
+	return errors
+}
+
+// readPatch applies the PATCH request body to the JSON representation of original and decodes
+// the result into dst. The format is picked by Content-Type: application/merge-patch+json
+// (plain application/json is treated the same way) or application/json-patch+json. A patch
+// that changes, adds or removes one of the readOnly members fails with a *readOnlyFieldsError.
+func (app *application) readPatch(w http.ResponseWriter, r *http.Request, original any, dst any, readOnly []string) error {
+	mediaType := "application/json"
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		var err error
+		mediaType, _, err = mime.ParseMediaType(contentType)
+		if err != nil {
+			return errUnsupportedPatchType
+		}
+	}
+
+	var apply func(document, patch []byte) ([]byte, error)
+	switch mediaType {
+	case "application/json", jsonpatch.MergePatchType:
+		apply = jsonpatch.MergePatch
+	case jsonpatch.JSONPatchType:
+		apply = jsonpatch.Apply
+	default:
+		return errUnsupportedPatchType
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, 1_048_576)
+	patch, err := io.ReadAll(r.Body)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			return newRequestError("body_too_large", maxBytesError.Limit)
+		}
+		return err
+	}
+	if len(bytes.TrimSpace(patch)) == 0 {
+		return newRequestError("body_empty")
+	}
+
+	document, err := json.Marshal(original)
+	if err != nil {
+		return err
+	}
+
+	patched, err := apply(document, patch)
+	if err != nil {
+		return err
+	}
+
+	var before, after map[string]json.RawMessage
+	if json.Unmarshal(document, &before) == nil && json.Unmarshal(patched, &after) == nil {
+		var changed []string
+		for _, field := range readOnly {
+			value, ok := after[field]
+			if ok != (before[field] != nil) || ok && !jsonEqual(before[field], value) {
+				changed = append(changed, field)
+			}
+		}
+		if len(changed) > 0 {
+			return &readOnlyFieldsError{fields: changed}
+		}
+	}
+
+	return app.decodeJSON(bytes.NewReader(patched), dst)
+}
+
+// jsonEqual compares two JSON values regardless of whitespace and the order of object members.
+func jsonEqual(a, b json.RawMessage) bool {
+	var valueA, valueB any
+	if json.Unmarshal(a, &valueA) != nil || json.Unmarshal(b, &valueB) != nil {
+		return false
+	}
+	return reflect.DeepEqual(valueA, valueB)
+}
+
 func (app *application) background(fn func()) {
 	go func() {
 		defer func() {
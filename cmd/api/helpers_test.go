@@ -0,0 +1,175 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadPatch(t *testing.T) {
+	type ad struct {
+		ID             int64  `json:"id"`
+		SellerID       int64  `json:"seller_id"`
+		OrganizationID int64  `json:"organization_id,omitempty"`
+		Title          string `json:"title"`
+		Version        int    `json:"version"`
+	}
+	original := ad{ID: 1, SellerID: 2, Title: "Trek", Version: 3}
+	readOnly := []string{"id", "seller_id", "organization_id"}
+
+	tests := []struct {
+		name        string
+		contentType string
+		patch       string
+		want        ad
+		readOnly    []string
+		err         error
+	}{
+		{
+			name:        "merge patch",
+			contentType: "application/merge-patch+json",
+			patch:       `{"title": "Cube"}`,
+			want:        ad{ID: 1, SellerID: 2, Title: "Cube", Version: 3},
+		},
+		{
+			name:        "plain JSON",
+			contentType: "application/json; charset=utf-8",
+			patch:       `{"title": "Cube"}`,
+			want:        ad{ID: 1, SellerID: 2, Title: "Cube", Version: 3},
+		},
+		{
+			name:        "merge patch repeating read only fields",
+			contentType: "application/merge-patch+json",
+			patch:       `{"id": 1, "seller_id": 2, "title": "Cube"}`,
+			want:        ad{ID: 1, SellerID: 2, Title: "Cube", Version: 3},
+		},
+		{
+			name:        "merge patch changing the id",
+			contentType: "application/merge-patch+json",
+			patch:       `{"id": 7, "title": "Cube"}`,
+			readOnly:    []string{"id"},
+		},
+		{
+			name:        "merge patch changing the owners",
+			contentType: "application/merge-patch+json",
+			patch:       `{"seller_id": 7, "organization_id": 8}`,
+			readOnly:    []string{"seller_id", "organization_id"},
+		},
+		{
+			name:        "merge patch removing the seller",
+			contentType: "application/merge-patch+json",
+			patch:       `{"seller_id": null}`,
+			readOnly:    []string{"seller_id"},
+		},
+		{
+			name:        "JSON patch",
+			contentType: "application/json-patch+json",
+			patch:       `[{"op": "test", "path": "/version", "value": 3}, {"op": "replace", "path": "/title", "value": "Cube"}]`,
+			want:        ad{ID: 1, SellerID: 2, Title: "Cube", Version: 3},
+		},
+		{
+			name:        "JSON patch replacing the seller",
+			contentType: "application/json-patch+json",
+			patch:       `[{"op": "replace", "path": "/seller_id", "value": 7}]`,
+			readOnly:    []string{"seller_id"},
+		},
+		{
+			name:        "JSON patch adding an organization",
+			contentType: "application/json-patch+json",
+			patch:       `[{"op": "add", "path": "/organization_id", "value": 8}]`,
+			readOnly:    []string{"organization_id"},
+		},
+		{
+			name:        "JSON patch copying over the id",
+			contentType: "application/json-patch+json",
+			patch:       `[{"op": "copy", "from": "/version", "path": "/id"}]`,
+			readOnly:    []string{"id"},
+		},
+		{
+			name:        "JSON patch removing the id",
+			contentType: "application/json-patch+json",
+			patch:       `[{"op": "remove", "path": "/id"}]`,
+			readOnly:    []string{"id"},
+		},
+		{
+			name:        "unsupported content type",
+			contentType: "text/plain",
+			patch:       `{"title": "Cube"}`,
+			err:         errUnsupportedPatchType,
+		},
+	}
+
+	app := &application{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPatch, "/v1/ads/1", strings.NewReader(tt.patch))
+			r.Header.Set("Content-Type", tt.contentType)
+
+			var patched ad
+			err := app.readPatch(httptest.NewRecorder(), r, original, &patched, readOnly)
+
+			var readOnlyErr *readOnlyFieldsError
+			switch {
+			case tt.readOnly != nil:
+				if !errors.As(err, &readOnlyErr) {
+					t.Fatalf("readPatch returned %v, want a read only fields error", err)
+				}
+				if !reflect.DeepEqual(readOnlyErr.fields, tt.readOnly) {
+					t.Errorf("readPatch rejected %v, want %v", readOnlyErr.fields, tt.readOnly)
+				}
+			case tt.err != nil:
+				if !errors.Is(err, tt.err) {
+					t.Errorf("readPatch returned %v, want %v", err, tt.err)
+				}
+			default:
+				if err != nil {
+					t.Fatalf("readPatch returned %v", err)
+				}
+				if patched != tt.want {
+					t.Errorf("readPatch decoded %+v, want %+v", patched, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		code string
+	}{
+		{"valid", `{"title": "Trek"}`, ""},
+		{"empty", ``, "body_empty"},
+		{"truncated", `{"title": "Trek"`, "body_malformed_json"},
+		{"syntax error", `{"title": Trek}`, "body_malformed_json_at"},
+		{"wrong field type", `{"title": 7}`, "body_wrong_type_field"},
+		{"wrong type", `["Trek"]`, "body_wrong_type_at"},
+		{"unknown key", `{"title": "Trek", "seller_id": 7}`, "body_unknown_key"},
+		{"multiple values", `{"title": "Trek"} {"title": "Cube"}`, "body_multiple_values"},
+	}
+
+	app := &application{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var input struct {
+				Title string `json:"title"`
+			}
+			err := app.decodeJSON(strings.NewReader(tt.body), &input)
+
+			if tt.code == "" {
+				if err != nil {
+					t.Errorf("decodeJSON(%q) returned %v", tt.body, err)
+				}
+				return
+			}
+			var requestErr *requestError
+			if !errors.As(err, &requestErr) || requestErr.code != tt.code {
+				t.Errorf("decodeJSON(%q) returned %v, want %s", tt.body, err, tt.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"antipinegor/cyclingmarket/internal/data"
+	"antipinegor/cyclingmarket/internal/validator"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/tomasen/realip"
+)
+
+// replayedHeaders are the response headers stored with an idempotent request and sent again on replay.
+var replayedHeaders = []string{"Content-Type", "Location", "ETag"}
+
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// idempotent makes a POST handler safe to retry: the first response to a request with an
+// Idempotency-Key header is stored for 24 hours and replayed to retries with the same key.
+// Reusing a key with a different body is rejected, so is a retry while the first request runs.
+// Keys of anonymous requests are scoped by the client IP, clients can't replay each other's responses.
+func (app *application) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		v := validator.New()
+		if data.ValidateIdempotencyKey(v, key); !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, 1_048_576)
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			var maxBytesError *http.MaxBytesError
+			if errors.As(err, &maxBytesError) {
+				app.badRequestResponse(w, r, newRequestError("body_too_large", maxBytesError.Limit))
+				return
+			}
+			app.badRequestResponse(w, r, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		fingerprint := sha256.Sum256(append([]byte(r.Method+" "+r.URL.Path+"\n"), body...))
+		user := app.contextGetUser(r)
+		request := &data.IdempotentRequest{
+			UserID:      user.ID,
+			Key:         key,
+			Fingerprint: fingerprint[:],
+		}
+		if user.IsAnonymous() {
+			request.ClientIP = realip.FromRequest(r)
+		}
+
+		err = app.models.Idempotency.Insert(r.Context(), request)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrDuplicateIdempotencyKey):
+				app.replayIdempotentResponse(w, r, request)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		// the key must be released or completed even if the client went away, a retry after a
+		// dropped connection is what the key is for. The model methods set their own timeouts.
+		ctx := context.WithoutCancel(r.Context())
+
+		defer func() {
+			if panicError := recover(); panicError != nil {
+				app.models.Idempotency.Delete(ctx, request.UserID, request.ClientIP, request.Key)
+				panic(panicError)
+			}
+		}()
+
+		rec := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		// server errors are not stored, the client should be able to retry them
+		if rec.status == 0 || rec.status >= http.StatusInternalServerError {
+			err = app.models.Idempotency.Delete(ctx, request.UserID, request.ClientIP, request.Key)
+			if err != nil {
+				app.logError(r, err)
+			}
+			return
+		}
+
+		request.Status = rec.status
+		request.Headers = make(http.Header)
+		for _, name := range replayedHeaders {
+			if value := w.Header().Get(name); value != "" {
+				request.Headers.Set(name, value)
+			}
+		}
+		request.Body = rec.body.Bytes()
+
+		err = app.models.Idempotency.SaveResponse(ctx, request)
+		if err != nil {
+			app.logError(r, err)
+		}
+	}
+}
+
+func (app *application) replayIdempotentResponse(w http.ResponseWriter, r *http.Request, request *data.IdempotentRequest) {
+	stored, err := app.models.Idempotency.Get(r.Context(), request.UserID, request.ClientIP, request.Key)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			// the first request failed and released the key in the meantime
+			app.idempotencyConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !bytes.Equal(stored.Fingerprint, request.Fingerprint) {
+		app.idempotencyKeyReusedResponse(w, r)
+		return
+	}
+
+	if stored.Status == 0 {
+		app.idempotencyConflictResponse(w, r)
+		return
+	}
+
+	for name, values := range stored.Headers {
+		w.Header()[name] = values
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(stored.Status)
+	w.Write(stored.Body)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"antipinegor/cyclingmarket/internal/data"
+	"antipinegor/cyclingmarket/internal/i18n"
+	"antipinegor/cyclingmarket/internal/validator"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// addAdImageHandler links an image to an ad. Without a position the image is appended.
+func (app *application) addAdImageHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var req struct {
+		URL      string `json:"url"`
+		Position *int   `json:"position"`
+	}
+
+	err = app.readJSON(w, r, &req)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ad, err := app.models.Ads.GetById(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	permitted, err := app.canManageAd(r, ad)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !permitted {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	image := &data.AdImage{AdID: ad.ID, URL: req.URL, Position: -1}
+	if req.Position != nil {
+		image.Position = *req.Position
+	}
+
+	v := validator.New()
+	data.ValidateAdImage(v, image)
+	v.Check(req.Position == nil || *req.Position >= 0, "position", validator.NewMessage("not_negative"))
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	audit, err := app.newAuditEntry(r, data.AuditAdImageAdd, data.AuditEntityAd, ad.ID, nil, envelope{"image": image})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.AdImages.Insert(r.Context(), image, audit)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrTooManyImages):
+			v.AddError("images", validator.NewMessage("too_many_images", data.MaxAdImages))
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"image": image}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteAdImageHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	imageID, err := strconv.ParseInt(httprouter.ParamsFromContext(r.Context()).ByName("image_id"), 10, 64)
+	if err != nil || imageID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	ad, err := app.models.Ads.GetById(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	permitted, err := app.canManageAd(r, ad)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !permitted {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	audit, err := app.newAuditEntry(r, data.AuditAdImageRemove, data.AuditEntityAd, ad.ID,
+		envelope{"image": envelope{"id": imageID}}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.AdImages.Delete(r.Context(), ad.ID, imageID, audit)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": i18n.Text(app.locale(r), "image_removed")}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
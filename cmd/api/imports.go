@@ -0,0 +1,485 @@
+package main
+
+import (
+	"antipinegor/cyclingmarket/internal/data"
+	"antipinegor/cyclingmarket/internal/i18n"
+	"antipinegor/cyclingmarket/internal/validator"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxImportRows      = 5000
+	importBatchSize    = 100
+	csvCategorySep     = ";"
+	ndjsonContentType  = "application/x-ndjson"
+	importBodyMaxBytes = 10_485_760
+
+	// the server timeouts are meant for small JSON bodies, uploads and exports get longer
+	importReadTimeout  = time.Minute
+	exportWriteTimeout = 5 * time.Minute
+
+	// exportStatusTrailer tells whether the export is complete, a failure after the first row
+	// can't change the status code anymore
+	exportStatusTrailer = "Export-Status"
+
+	// imports not updated for staleImportTimeout were interrupted by a restart
+	staleImportTimeout = 10 * time.Minute
+)
+
+// adCSVColumns are the columns of CSV imports and exports, the first four are required on import.
+var adCSVColumns = []string{"title", "description", "categories", "price", "condition", "frame_serial"}
+
+// adRecord is a single ad in NDJSON imports and exports, it has the same shape as the body of POST /v1/ads.
+type adRecord struct {
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	Categories  []string   `json:"categories"`
+	Price       data.Price `json:"price"`
+	Condition   string     `json:"condition,omitempty"`
+	FrameSerial string     `json:"frame_serial,omitempty"`
+}
+
+type adImportRow struct {
+	row    int
+	record adRecord
+	errors map[string]validator.Message
+}
+
+func (app *application) createAdImportHandler(w http.ResponseWriter, r *http.Request) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		app.unsupportedImportTypeResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+	imp := &data.AdImport{
+		UserID: app.contextGetUser(r).ID,
+		Atomic: app.readBool(r.URL.Query(), "atomic", false, v),
+		Status: data.ImportStatusPending,
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	imp.OrganizationID, err = app.adOrganizationID(r, app.readString(r.URL.Query(), "organization", ""))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("organization", validator.NewMessage("unknown_organization"))
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, errNoAdsPermission):
+			app.notPermittedResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// imported ads are logged one by one, the entry is completed per ad
+	audit, err := app.newAuditEntry(r, data.AuditAdCreate, data.AuditEntityAd, 0, nil, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = http.NewResponseController(w).SetReadDeadline(time.Now().Add(importReadTimeout))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, importBodyMaxBytes)
+
+	var rows []*adImportRow
+	switch mediaType {
+	case "text/csv":
+		imp.Format = data.ImportFormatCSV
+		rows, err = readCSVImport(r.Body)
+	case ndjsonContentType, "application/jsonl":
+		imp.Format = data.ImportFormatNDJSON
+		rows, err = app.readNDJSONImport(r.Body)
+	default:
+		app.unsupportedImportTypeResponse(w, r)
+		return
+	}
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			err = newRequestError("body_too_large", maxBytesError.Limit)
+		}
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if len(rows) == 0 {
+		app.badRequestResponse(w, r, errors.New("body must contain at least one ad"))
+		return
+	}
+	if len(rows) > maxImportRows {
+		app.badRequestResponse(w, r, fmt.Errorf("body must not contain more than %d ads", maxImportRows))
+		return
+	}
+
+	imp.TotalRows = len(rows)
+	imp.Errors = []data.AdImportError{}
+
+	err = app.models.AdImports.Insert(r.Context(), imp)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/imports/ads/%d", imp.ID))
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"import": imp}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+
+	// started only after the response is written, processing modifies imp
+	locale := app.locale(r)
+	logger := app.contextLogger(r)
+	ctx := context.WithoutCancel(r.Context())
+	app.background(func() {
+		err := app.processAdImport(ctx, imp, rows, locale, audit)
+		if err != nil {
+			logger.Error(err.Error(), "import_id", imp.ID)
+
+			imp.Status = data.ImportStatusFailed
+			err = app.models.AdImports.Update(ctx, imp)
+			if err != nil {
+				logger.Error(err.Error(), "import_id", imp.ID)
+			}
+		}
+	})
+}
+
+// processAdImport validates every row like POST /v1/ads does and inserts the valid ones in
+// batches. Atomic imports are all or nothing: one invalid row fails the whole import. Row errors
+// are stored as texts in the locale of the upload. Every inserted ad is logged with a copy of audit.
+func (app *application) processAdImport(ctx context.Context, imp *data.AdImport, rows []*adImportRow, locale string, audit *data.AuditEntry) error {
+	imp.Status = data.ImportStatusProcessing
+	err := app.models.AdImports.Update(ctx, imp)
+	if err != nil {
+		return err
+	}
+
+	tree, err := app.models.Categories.GetTree(ctx)
+	if err != nil {
+		return err
+	}
+
+	var ads []*data.Ad
+	var adRows []int
+
+	for _, row := range rows {
+		if len(row.errors) > 0 {
+			imp.Errors = append(imp.Errors, data.AdImportError{Row: row.row, Errors: localizeErrors(locale, row.errors)})
+			continue
+		}
+
+		condition := row.record.Condition
+		if condition == "" {
+			condition = data.ConditionUsed
+		}
+		ad := &data.Ad{
+			Title:          row.record.Title,
+			Description:    row.record.Description,
+			Categories:     tree.Resolve(row.record.Categories),
+			Price:          row.record.Price,
+			Condition:      condition,
+			FrameSerial:    data.NormalizeSerial(row.record.FrameSerial),
+			SellerID:       imp.UserID,
+			OrganizationID: imp.OrganizationID,
+		}
+
+		v := validator.New()
+		data.ValidateAd(v, ad, tree)
+		if v.Valid() && ad.FrameSerial != "" {
+			stolen, err := app.models.StolenBikes.IsStolen(ctx, ad.FrameSerial)
+			if err != nil {
+				return err
+			}
+			if stolen {
+				app.logger.Warn("attempt to import a bike reported as stolen", "serial", ad.FrameSerial, "user_id", imp.UserID, "import_id", imp.ID)
+				v.AddError("frame_serial", validator.NewMessage("stolen_frame_serial"))
+			}
+		}
+		if !v.Valid() {
+			imp.Errors = append(imp.Errors, data.AdImportError{Row: row.row, Errors: localizeErrors(locale, v.Errors)})
+			continue
+		}
+
+		ads = append(ads, ad)
+		adRows = append(adRows, row.row)
+	}
+
+	if imp.Atomic {
+		if len(imp.Errors) == 0 {
+			err = app.models.Ads.InsertBatch(ctx, ads, audit)
+			if err != nil {
+				return err
+			}
+			imp.ImportedRows = len(ads)
+		}
+	} else {
+		for start := 0; start < len(ads); start += importBatchSize {
+			end := min(start+importBatchSize, len(ads))
+
+			err = app.models.Ads.InsertBatch(ctx, ads[start:end], audit)
+			if err != nil {
+				app.logger.Error(err.Error(), "import_id", imp.ID)
+				for _, row := range adRows[start:end] {
+					imp.Errors = append(imp.Errors, data.AdImportError{Row: row, Errors: map[string]string{"ad": i18n.Text(locale, "import_not_saved")}})
+				}
+			} else {
+				imp.ImportedRows += end - start
+			}
+
+			// the progress keeps the import from being failed as stale
+			err = app.models.AdImports.Update(ctx, imp)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	slices.SortFunc(imp.Errors, func(a, b data.AdImportError) int {
+		return a.Row - b.Row
+	})
+
+	imp.Status = data.ImportStatusCompleted
+	if imp.Atomic && len(imp.Errors) > 0 {
+		imp.Status = data.ImportStatusFailed
+	}
+
+	return app.models.AdImports.Update(ctx, imp)
+}
+
+func readCSVImport(body io.Reader) ([]*adImportRow, error) {
+	reader := csv.NewReader(body)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, newRequestError("body_empty")
+		}
+		return nil, fmt.Errorf("body contains badly-formed CSV: %w", err)
+	}
+
+	index := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !slices.Contains(adCSVColumns, name) {
+			return nil, fmt.Errorf("CSV header contains unknown column %q", name)
+		}
+		index[name] = i
+	}
+	for _, name := range adCSVColumns[:4] {
+		if _, ok := index[name]; !ok {
+			return nil, fmt.Errorf("CSV header must contain the %q column", name)
+		}
+	}
+
+	var rows []*adImportRow
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("body contains badly-formed CSV: %w", err)
+		}
+
+		row := &adImportRow{row: len(rows) + 1, errors: make(map[string]validator.Message)}
+		field := func(name string) string {
+			i, ok := index[name]
+			if !ok {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+
+		row.record.Title = field("title")
+		row.record.Description = field("description")
+		row.record.Condition = field("condition")
+		row.record.FrameSerial = field("frame_serial")
+
+		row.record.Categories = []string{}
+		for _, category := range strings.Split(field("categories"), csvCategorySep) {
+			if category = strings.TrimSpace(category); category != "" {
+				row.record.Categories = append(row.record.Categories, category)
+			}
+		}
+
+		price, err := strconv.ParseInt(strings.TrimSuffix(field("price"), " $"), 10, 32)
+		if err != nil {
+			row.errors["price"] = validator.NotInteger
+		}
+		row.record.Price = data.Price(price)
+
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+func (app *application) readNDJSONImport(body io.Reader) ([]*adImportRow, error) {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1_048_576)
+
+	var rows []*adImportRow
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		row := &adImportRow{row: len(rows) + 1, errors: make(map[string]validator.Message)}
+		err := app.decodeJSON(bytes.NewReader(line), &row.record)
+		if err != nil {
+			row.errors["ad"] = validator.NewMessage("invalid_json", err.Error())
+		}
+
+		rows = append(rows, row)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return rows, nil
+}
+
+func (app *application) showAdImportHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	imp, err := app.models.AdImports.GetForUser(r.Context(), id, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"import": imp}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// exportAdsHandler streams the ads of the authenticated seller as CSV or NDJSON,
+// in the same format the import endpoint accepts. The Export-Status trailer is "complete" or
+// "failed", failed NDJSON exports also end with an {"error": ...} record.
+func (app *application) exportAdsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	format := app.readString(r.URL.Query(), "format", data.ImportFormatCSV)
+	v.Check(validator.PermittedValue(format, data.ImportFormatCSV, data.ImportFormatNDJSON), "format", validator.OneOf(data.ImportFormatCSV, data.ImportFormatNDJSON))
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	err := http.NewResponseController(w).SetWriteDeadline(time.Now().Add(exportWriteTimeout))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Set("Trailer", exportStatusTrailer)
+
+	var write func(*data.Ad) error
+	var flush func() error
+	var fail func() error
+
+	switch format {
+	case data.ImportFormatCSV:
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="ads.csv"`)
+
+		writer := csv.NewWriter(w)
+		err := writer.Write(adCSVColumns)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		write = func(ad *data.Ad) error {
+			return writer.Write([]string{
+				ad.Title,
+				ad.Description,
+				strings.Join(ad.Categories, csvCategorySep),
+				strconv.FormatInt(int64(ad.Price), 10),
+				ad.Condition,
+				ad.FrameSerial,
+			})
+		}
+		flush = func() error {
+			writer.Flush()
+			return writer.Error()
+		}
+		fail = func() error {
+			return nil
+		}
+
+	case data.ImportFormatNDJSON:
+		w.Header().Set("Content-Type", ndjsonContentType)
+		w.Header().Set("Content-Disposition", `attachment; filename="ads.ndjson"`)
+
+		encoder := json.NewEncoder(w)
+		write = func(ad *data.Ad) error {
+			return encoder.Encode(adRecord{
+				Title:       ad.Title,
+				Description: ad.Description,
+				Categories:  ad.Categories,
+				Price:       ad.Price,
+				Condition:   ad.Condition,
+				FrameSerial: ad.FrameSerial,
+			})
+		}
+		flush = func() error {
+			return nil
+		}
+		fail = func() error {
+			return encoder.Encode(envelope{"error": i18n.Text(app.locale(r), "export_failed")})
+		}
+	}
+
+	err = app.models.Ads.ForEachBySeller(r.Context(), user.ID, exportWriteTimeout, write)
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		app.logError(r, err)
+
+		w.Header().Set(exportStatusTrailer, "failed")
+		err = fail()
+		if err != nil {
+			app.logError(r, err)
+		}
+		return
+	}
+	w.Header().Set(exportStatusTrailer, "complete")
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// runPeriodically runs fn in the background every interval until the process exits.
+// A failed or panicking run is logged and the next one is attempted as usual. Every run
+// gets its own trace.
+func (app *application) runPeriodically(name string, interval time.Duration, fn func(context.Context) error) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			func() {
+				defer func() {
+					if err := recover(); err != nil {
+						app.logger.Error(fmt.Sprintf("%v", err), "job", name)
+					}
+				}()
+
+				ctx, span := tracer.Start(context.Background(), name, trace.WithNewRoot())
+				defer span.End()
+
+				if err := fn(ctx); err != nil {
+					span.SetStatus(codes.Error, err.Error())
+					app.logger.Error(err.Error(), "job", name)
+				}
+			}()
+		}
+	}()
+}
+
+func (app *application) failStaleImports(ctx context.Context) error {
+	failed, err := app.models.AdImports.FailStale(ctx, staleImportTimeout)
+	if failed > 0 {
+		app.logger.Warn("interrupted ad imports failed", "count", failed)
+	}
+	return err
+}
+
+func (app *application) purgeDeletedAds(ctx context.Context) error {
+	purged, err := app.models.Ads.PurgeDeleted(ctx, app.config.ads.deletedRetention)
+	if purged > 0 {
+		app.logger.Info("deleted ads purged", "count", purged)
+	}
+	return err
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/rand"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/tomasen/realip"
+)
+
+const (
+	requestIDHeader    = "X-Request-ID"
+	maxRequestIDLength = 128
+)
+
+// validRequestID accepts the IDs of clients and proxies if they are short printable ASCII,
+// anything else would end up in logs and response headers verbatim.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < '!' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// loggingResponseWriter remembers the status and the size of the response for the access log.
+type loggingResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	bytes       int
+	wroteHeader bool
+}
+
+func (lw *loggingResponseWriter) WriteHeader(status int) {
+	if !lw.wroteHeader {
+		lw.status = status
+		lw.wroteHeader = true
+	}
+	lw.ResponseWriter.WriteHeader(status)
+}
+
+func (lw *loggingResponseWriter) Write(b []byte) (int, error) {
+	if !lw.wroteHeader {
+		lw.WriteHeader(http.StatusOK)
+	}
+	n, err := lw.ResponseWriter.Write(b)
+	lw.bytes += n
+	return n, err
+}
+
+func (lw *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return lw.ResponseWriter
+}
+
+// logRequests gives every request an ID, taken from X-Request-ID if the client sent a valid one,
+// puts a logger with that ID into the request context and writes an access log line and the
+// request metrics once the response is sent. It wraps all other middleware, so failed and rejected requests are logged too.
+func (app *application) logRequests(next http.Handler) http.Handler {
+	wrappedFunction := func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = rand.Text()
+		}
+		w.Header().Set(requestIDHeader, id)
+
+		info := &requestInfo{id: id, logger: app.logger.With("request_id", id)}
+		r = app.contextSetRequestInfo(r, info)
+
+		lw := &loggingResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(lw, r)
+
+		duration := time.Since(start)
+		app.metrics.observeRequest(r.Method, info.route, lw.status, duration)
+
+		info.logger.Info("request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"route", info.route,
+			"status", lw.status,
+			"bytes", lw.bytes,
+			"duration_ms", float64(duration.Microseconds())/1000,
+			"user_id", info.userID,
+			"ip", realip.FromRequest(r),
+		)
+	}
+
+	return http.HandlerFunc(wrappedFunction)
+}
+
+// newLogger writes text or JSON records to stdout depending on the log-format flag.
+func newLogger(format string) *slog.Logger {
+	if format == "json" {
+		return slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	}
+	return slog.New(slog.NewTextHandler(os.Stdout, nil))
+}
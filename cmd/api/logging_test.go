@@ -0,0 +1,34 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidRequestID(t *testing.T) {
+	tests := []struct {
+		name string
+		id   string
+		want bool
+	}{
+		{"uuid", "0b9c4f3e-5b0e-4c1a-9f4e-2f6d8a7b1c2d", true},
+		{"printable", "req_42:edge/1~!", true},
+		{"longest", strings.Repeat("a", maxRequestIDLength), true},
+		{"empty", "", false},
+		{"too long", strings.Repeat("a", maxRequestIDLength+1), false},
+		{"space", "req 42", false},
+		{"header injection", "req42\r\nSet-Cookie: token=x", false},
+		{"log injection", "req42\nlevel=ERROR", false},
+		{"control character", "req\x1b[31m42", false},
+		{"delete", "req42\x7f", false},
+		{"non ASCII", "запрос42", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validRequestID(tt.id); got != tt.want {
+				t.Errorf("validRequestID(%q) = %t, want %t", tt.id, got, tt.want)
+			}
+		})
+	}
+}
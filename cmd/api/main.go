@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
 	"time"
 
@@ -18,9 +19,12 @@ import (
 const version = "1.0.0"
 
 type config struct {
-	port  int
-	state string
-	db    struct {
+	port        int
+	state       string
+	logFormat   string
+	metricsAddr string
+	appURL      string
+	db          struct {
 		dsn          string
 		maxOpenConns int
 		maxIdleConns int
@@ -31,20 +35,33 @@ type config struct {
 		burst   int
 		enabled bool
 	}
+	ads struct {
+		deletedRetention time.Duration
+	}
+	mail struct {
+		transport string
+		dir       string
+	}
+	otel struct {
+		exporter    string
+		sampleRatio float64
+	}
 	smtp struct {
 		host     string
 		port     int
 		username string
 		password string
+		tls      string
 		sender   string
 	}
 }
 
 type application struct {
-	config config
-	logger *slog.Logger
-	models data.Models
-	mailer *mailer.Mailer
+	config  config
+	logger  *slog.Logger
+	models  data.Models
+	mailer  *mailer.Mailer
+	metrics *appMetrics
 }
 
 func main() {
@@ -52,6 +69,9 @@ func main() {
 
 	flag.IntVar(&cfg.port, "port", 4000, "api server port")
 	flag.StringVar(&cfg.state, "state", "development", "state")
+	flag.StringVar(&cfg.logFormat, "log-format", "text", "log output format (text|json)")
+	flag.StringVar(&cfg.metricsAddr, "metrics-addr", "localhost:9090", "address of the Prometheus /metrics endpoint, empty to disable")
+	flag.StringVar(&cfg.appURL, "app-url", "http://localhost:3000", "base URL of the web app the links in emails point to")
 
 	flag.StringVar(&cfg.db.dsn, "db-dsn", os.Getenv("CYCLINGMARKET_DB_DSN"), "psql dns")
 	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "postgreSQL max open connections")
@@ -62,13 +82,45 @@ func main() {
 	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "rate limiter maximum burst")
 	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "enable rate limiter")
 
+	flag.DurationVar(&cfg.ads.deletedRetention, "ads-deleted-retention", 30*24*time.Hour, "how long deleted ads are kept before they are purged")
+
+	flag.StringVar(&cfg.mail.transport, "mail-transport", "smtp", "how emails are delivered (smtp|maildir|log|memory), memory only in development")
+	flag.StringVar(&cfg.mail.dir, "mail-dir", "tmp/maildir", "maildir the maildir transport writes emails to")
+
+	flag.StringVar(&cfg.otel.exporter, "otel-exporter", "none", "where traces are exported (none|stdout|otlp), otlp is configured with the OTEL_EXPORTER_OTLP_* variables")
+	flag.Float64Var(&cfg.otel.sampleRatio, "otel-sample-ratio", 1, "fraction of new traces that are sampled")
+
 	flag.StringVar(&cfg.smtp.host, "smtp-host", "localhost", "SMTP host")
 	flag.IntVar(&cfg.smtp.port, "smtp-port", 1025, "SMTP port")
+	flag.StringVar(&cfg.smtp.username, "smtp-username", "", "SMTP username, no authentication if empty")
+	flag.StringVar(&cfg.smtp.password, "smtp-password", "", "SMTP password")
+	flag.StringVar(&cfg.smtp.tls, "smtp-tls", mailer.TLSNone, "SMTP encryption (none|starttls|tls)")
 	flag.StringVar(&cfg.smtp.sender, "smtp-sender", "CyclingMarket <no-reply@cyclingmarket.ru>", "SMTP sender")
 
 	flag.Parse()
 
-	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	logger := newLogger(cfg.logFormat)
+
+	if cfg.logFormat != "text" && cfg.logFormat != "json" {
+		logger.Error("log-format must be text or json", "log_format", cfg.logFormat)
+		os.Exit(1)
+	}
+
+	if u, err := url.Parse(cfg.appURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		logger.Error("app-url must be an absolute http or https URL", "app_url", cfg.appURL)
+		os.Exit(1)
+	}
+
+	if cfg.ads.deletedRetention < data.AdRestoreWindow {
+		logger.Error("ads-deleted-retention must not be shorter than the restore window", "restore_window", data.AdRestoreWindow.String())
+		os.Exit(1)
+	}
+
+	shutdownTracing, err := setupTracing(cfg)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
 
 	db, err := openDB(cfg)
 	if err != nil {
@@ -79,19 +131,38 @@ func main() {
 	defer db.Close()
 	logger.Info("db connection pool established")
 
-	mailer, err := mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.sender)
+	transport, err := newMailTransport(cfg, logger)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	mailer, err := mailer.New(transport, cfg.smtp.sender)
 	if err != nil {
 		logger.Error(err.Error())
 		os.Exit(1)
 	}
 
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
-		mailer: mailer,
+		config:  cfg,
+		logger:  logger,
+		models:  data.NewModels(db),
+		mailer:  mailer,
+		metrics: newMetrics(db),
+	}
+
+	if cfg.metricsAddr != "" {
+		app.serveMetrics(cfg.metricsAddr)
 	}
 
+	app.runPeriodically("purge idempotency keys", time.Hour, app.models.Idempotency.DeleteExpired)
+	app.runPeriodically("send renewal reminders", 15*time.Minute, app.sendRenewalReminders)
+	app.runPeriodically("purge deleted ads", time.Hour, app.purgeDeletedAds)
+	app.runPeriodically("deliver email outbox", 10*time.Second, app.deliverOutbox)
+	app.runPeriodically("fail stale ad imports", 5*time.Minute, app.failStaleImports)
+	app.runPeriodically("purge search queries", time.Hour, app.models.Suggestions.PurgeQueries)
+	app.runPeriodically("refresh suggestion counts", 5*time.Minute, app.models.Suggestions.RefreshCounts)
+
 	httpServer := &http.Server{
 		Addr:         fmt.Sprintf(":%d", cfg.port),
 		Handler:      app.routes(),
@@ -105,11 +176,17 @@ func main() {
 
 	err = httpServer.ListenAndServe()
 	logger.Error(err.Error())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(ctx); err != nil {
+		logger.Error(err.Error())
+	}
 	os.Exit(1)
 }
 
 func openDB(cfg config) (*sql.DB, error) {
-	db, err := sql.Open("postgres", cfg.db.dsn)
+	db, err := data.OpenDB(cfg.db.dsn)
 	if err != nil {
 		return nil, err
 	}
@@ -129,3 +206,29 @@ func openDB(cfg config) (*sql.DB, error) {
 
 	return db, nil
 }
+
+// newMailTransport picks the email delivery selected by the mail-transport flag.
+func newMailTransport(cfg config, logger *slog.Logger) (mailer.Transport, error) {
+	switch cfg.mail.transport {
+	case "smtp":
+		return mailer.NewSMTPTransport(mailer.SMTPConfig{
+			Host:     cfg.smtp.host,
+			Port:     cfg.smtp.port,
+			Username: cfg.smtp.username,
+			Password: cfg.smtp.password,
+			TLS:      cfg.smtp.tls,
+		})
+	case "maildir":
+		return mailer.NewMaildirTransport(cfg.mail.dir)
+	case "log":
+		return mailer.NewLogTransport(logger), nil
+	case "memory":
+		// the messages are never read outside of tests, a server would only pile them up
+		if cfg.state != "development" {
+			return nil, fmt.Errorf("mail transport %q is only allowed in the development state", cfg.mail.transport)
+		}
+		return mailer.NewMemoryTransport(), nil
+	default:
+		return nil, fmt.Errorf("unknown mail transport %q", cfg.mail.transport)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"antipinegor/cyclingmarket/internal/metrics"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type appMetrics struct {
+	registry            *metrics.Registry
+	requests            *metrics.CounterVec
+	requestDuration     *metrics.HistogramVec
+	rateLimitRejections *metrics.CounterVec
+	tokenFailures       *metrics.CounterVec
+	mailSends           *metrics.CounterVec
+}
+
+func newMetrics(db *sql.DB) *appMetrics {
+	registry := metrics.NewRegistry()
+
+	m := &appMetrics{
+		registry: registry,
+		requests: registry.NewCounterVec("http_requests_total",
+			"HTTP requests by route pattern and status.", "method", "route", "status"),
+		requestDuration: registry.NewHistogramVec("http_request_duration_seconds",
+			"HTTP request latencies by route pattern.", metrics.DefaultBuckets, "method", "route"),
+		rateLimitRejections: registry.NewCounterVec("rate_limit_rejections_total",
+			"Requests rejected by the rate limiter."),
+		tokenFailures: registry.NewCounterVec("token_validation_failures_total",
+			"Tokens that failed validation by scope and reason.", "scope", "reason"),
+		mailSends: registry.NewCounterVec("mail_sends_total",
+			"Email send attempts by template and outcome.", "template", "outcome"),
+	}
+
+	dbStat := func(fn func(sql.DBStats) float64) func() float64 {
+		return func() float64 { return fn(db.Stats()) }
+	}
+	registry.NewGaugeFunc("db_open_connections", "Established database connections, in use and idle.",
+		dbStat(func(s sql.DBStats) float64 { return float64(s.OpenConnections) }))
+	registry.NewGaugeFunc("db_in_use_connections", "Database connections currently in use.",
+		dbStat(func(s sql.DBStats) float64 { return float64(s.InUse) }))
+	registry.NewGaugeFunc("db_idle_connections", "Idle database connections.",
+		dbStat(func(s sql.DBStats) float64 { return float64(s.Idle) }))
+	registry.NewGaugeFunc("db_max_open_connections", "Maximum number of open database connections.",
+		dbStat(func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) }))
+	registry.NewCounterFunc("db_wait_count_total", "Connections waited for because the pool was exhausted.",
+		dbStat(func(s sql.DBStats) float64 { return float64(s.WaitCount) }))
+	registry.NewCounterFunc("db_wait_duration_seconds_total", "Time spent waiting for a connection.",
+		dbStat(func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() }))
+	registry.NewCounterFunc("db_max_idle_closed_total", "Connections closed because of the idle connections limit.",
+		dbStat(func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) }))
+	registry.NewCounterFunc("db_max_idle_time_closed_total", "Connections closed because of the idle time limit.",
+		dbStat(func(s sql.DBStats) float64 { return float64(s.MaxIdleTimeClosed) }))
+
+	return m
+}
+
+// observeRequest counts a finished request under the pattern of its route, requests that
+// matched no route share one label value so that unknown paths can not grow the series.
+func (m *appMetrics) observeRequest(method, route string, status int, duration time.Duration) {
+	if route == "" {
+		route = "unmatched"
+	}
+	m.requests.Inc(method, route, strconv.Itoa(status))
+	m.requestDuration.Observe(duration.Seconds(), method, route)
+}
+
+// serveMetrics exposes the metrics on their own address, so that they are not reachable
+// through the public port of the API.
+func (app *application) serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", app.metrics.registry.Handler())
+
+	server := &http.Server{
+		Addr:         addr,
+		Handler:      mux,
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		app.logger.Info("starting metrics server", "addr", addr)
+		err := server.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			app.logger.Error(err.Error(), "server", "metrics")
+		}
+	}()
+}
@@ -10,6 +10,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/julienschmidt/httprouter"
 	"github.com/tomasen/realip"
 	"golang.org/x/time/rate"
 )
@@ -70,6 +71,7 @@ func (app *application) rateLimit(nextHandler http.Handler) http.Handler {
 
 		if !clients[ip].limiter.Allow() {
 			mutex.Unlock()
+			app.metrics.rateLimitRejections.Inc()
 			app.rateLimitExceededResponse(w, r)
 			return
 		}
@@ -94,6 +96,7 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 
 		headerParts := strings.Split(authorizationHeader, " ")
 		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+			app.metrics.tokenFailures.Inc(data.ScopeAuthentication, "malformed")
 			app.invalidAuthenticationTokenResponse(w, r)
 			return
 		}
@@ -101,14 +104,16 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 
 		v := validator.New()
 		if data.ValidateTokenPlaintext(v, token); !v.Valid() {
+			app.metrics.tokenFailures.Inc(data.ScopeAuthentication, "malformed")
 			app.invalidAuthenticationTokenResponse(w, r)
 			return
 		}
 
-		user, err := app.models.Users.GetForToken(data.ScopeAuthentication, token)
+		user, err := app.models.Users.GetForToken(r.Context(), data.ScopeAuthentication, token)
 		if err != nil {
 			switch {
 			case errors.Is(err, data.ErrRecordNotFound):
+				app.metrics.tokenFailures.Inc(data.ScopeAuthentication, "invalid")
 				app.invalidAuthenticationTokenResponse(w, r)
 			default:
 				app.serverErrorResponse(w, r, err)
@@ -155,7 +160,7 @@ func (app *application) requirePermission(code string, next http.HandlerFunc) ht
 	wrappedFunction := func(w http.ResponseWriter, r *http.Request) {
 		user := app.contextGetUser(r)
 
-		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+		permissions, err := app.models.Permissions.GetAllForUser(r.Context(), user.ID)
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
 			return
@@ -171,3 +176,38 @@ func (app *application) requirePermission(code string, next http.HandlerFunc) ht
 
 	return app.requireActivatedUser(wrappedFunction)
 }
+
+// requireOrganizationPermission works like requirePermission for routes under /v1/organizations/:slug,
+// the permission comes from the role the user has in that organization. The organization is put
+// into the request context for the handler.
+func (app *application) requireOrganizationPermission(code string, next http.HandlerFunc) http.HandlerFunc {
+	wrappedFunction := func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+
+		org, err := app.models.Organizations.GetBySlug(r.Context(), httprouter.ParamsFromContext(r.Context()).ByName("slug"))
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		permitted, err := app.hasOrganizationPermission(r, org.ID, user.ID, code)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if !permitted {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
+		r = app.contextSetOrganization(r, org)
+		next.ServeHTTP(w, r)
+	}
+
+	return app.requireActivatedUser(wrappedFunction)
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"antipinegor/cyclingmarket/internal/data"
+	"antipinegor/cyclingmarket/internal/i18n"
+	"antipinegor/cyclingmarket/internal/jsonpatch"
+	"antipinegor/cyclingmarket/internal/validator"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// hasOrganizationPermission reports whether the user is a member of the organization
+// with a role that grants the permission code.
+func (app *application) hasOrganizationPermission(r *http.Request, organizationID, userID int64, code string) (bool, error) {
+	role, err := app.models.Organizations.GetRole(r.Context(), organizationID, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+	return data.RolePermissions[role].Include(code), nil
+}
+
+// canManageAd reports whether the user of the request may edit or delete the ad. Ads of an
+// organization are managed by its members with the ads:write role permission, the seller loses
+// access once they leave it. Private ads are managed by their seller. Users with the ads:moderate
+// permission manage every ad, they are the only ones who can manage ads without an owner.
+func (app *application) canManageAd(r *http.Request, ad *data.Ad) (bool, error) {
+	user := app.contextGetUser(r)
+
+	switch {
+	case ad.OrganizationID != 0:
+		permitted, err := app.hasOrganizationPermission(r, ad.OrganizationID, user.ID, "ads:write")
+		if err != nil || permitted {
+			return permitted, err
+		}
+	case ad.SellerID != 0 && ad.SellerID == user.ID:
+		return true, nil
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(r.Context(), user.ID)
+	if err != nil {
+		return false, err
+	}
+	return permissions.Include("ads:moderate"), nil
+}
+
+func (app *application) createOrganizationHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name         string            `json:"name"`
+		Slug         string            `json:"slug"`
+		Description  string            `json:"description"`
+		LogoURL      string            `json:"logo_url"`
+		OpeningHours map[string]string `json:"opening_hours"`
+	}
+
+	err := app.readJSON(w, r, &req)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	org := &data.Organization{
+		Name:         req.Name,
+		Slug:         req.Slug,
+		Description:  req.Description,
+		LogoURL:      req.LogoURL,
+		OpeningHours: req.OpeningHours,
+	}
+	if org.OpeningHours == nil {
+		org.OpeningHours = map[string]string{}
+	}
+
+	v := validator.New()
+	if data.ValidateOrganization(v, org); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	audit, err := app.newAuditEntry(r, data.AuditOrganizationCreate, data.AuditEntityOrganization, 0, nil, org)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Organizations.Insert(r.Context(), org, app.contextGetUser(r).ID, audit)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateSlug):
+			v.AddError("slug", validator.NewMessage("duplicate_slug"))
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/organizations/%s", org.Slug))
+	err = app.writeJSON(w, http.StatusCreated, envelope{"organization": org}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showStorefrontHandler is the public page of a dealer: its profile and a page of its ads.
+func (app *application) showStorefrontHandler(w http.ResponseWriter, r *http.Request) {
+	org, err := app.models.Organizations.GetBySlug(r.Context(), httprouter.ParamsFromContext(r.Context()).ByName("slug"))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	v := validator.New()
+	queryString := r.URL.Query()
+	filters := data.Filters{
+		Page:         app.readInt(queryString, "page", 1, v),
+		PageSize:     app.readInt(queryString, "page_size", 20, v),
+		Sort:         app.readString(queryString, "sort", "-id"),
+		SortSafelist: []string{"id", "price", "-id", "-price"},
+	}
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ads, metadata, err := app.models.Ads.GetAllForOrganization(r.Context(), org.ID, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeCachedJSON(w, r, envelope{"organization": org, "ads": ads, "metadata": metadata}, "", time.Time{})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateOrganizationHandler(w http.ResponseWriter, r *http.Request) {
+	org := app.contextGetOrganization(r)
+
+	var patched data.Organization
+	err := app.readPatch(w, r, org, &patched, data.OrganizationReadOnlyFields)
+	if err != nil {
+		var readOnlyErr *readOnlyFieldsError
+		switch {
+		case errors.As(err, &readOnlyErr):
+			app.failedValidationResponse(w, r, readOnlyErr.validationErrors())
+		case errors.Is(err, errUnsupportedPatchType):
+			app.unsupportedPatchTypeResponse(w, r)
+		case errors.Is(err, jsonpatch.ErrTestFailed):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, jsonpatch.ErrInapplicablePatch):
+			app.invalidPatchResponse(w, r, err)
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+
+	if patched.Version != org.Version {
+		app.editConflictResponse(w, r)
+		return
+	}
+
+	original := org
+
+	patched.CreatedAt = org.CreatedAt
+	if patched.OpeningHours == nil {
+		patched.OpeningHours = map[string]string{}
+	}
+	org = &patched
+
+	v := validator.New()
+	if data.ValidateOrganization(v, org); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	audit, err := app.newAuditEntry(r, data.AuditOrganizationUpdate, data.AuditEntityOrganization, org.ID, original, org)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Organizations.Update(r.Context(), org, audit)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateSlug):
+			v.AddError("slug", validator.NewMessage("duplicate_slug"))
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"organization": org}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listMembersHandler(w http.ResponseWriter, r *http.Request) {
+	org := app.contextGetOrganization(r)
+
+	members, err := app.models.Organizations.GetMembers(r.Context(), org.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"members": members}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) addMemberHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Email string `json:"email"`
+		Role  string `json:"role"`
+	}
+
+	err := app.readJSON(w, r, &req)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateEmail(v, req.Email)
+	data.ValidateRole(v, req.Role)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	org := app.contextGetOrganization(r)
+
+	user, err := app.models.Users.GetByEmail(r.Context(), req.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("email", validator.NewMessage("unknown_email"))
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	audit, err := app.newAuditEntry(r, data.AuditOrganizationMemberAdd, data.AuditEntityOrganization, org.ID, nil,
+		envelope{"member": envelope{"user_id": user.ID, "role": req.Role}})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Organizations.AddMember(r.Context(), org.ID, user.ID, req.Role, audit)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateMember):
+			v.AddError("email", validator.NewMessage("duplicate_member"))
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	member := &data.Member{UserID: user.ID, Name: user.Name, Email: user.Email, Role: req.Role, JoinedAt: time.Now()}
+	err = app.writeJSON(w, http.StatusCreated, envelope{"member": member}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) removeMemberHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(httprouter.ParamsFromContext(r.Context()).ByName("user_id"), 10, 64)
+	if err != nil || userID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	org := app.contextGetOrganization(r)
+
+	audit, err := app.newAuditEntry(r, data.AuditOrganizationMemberRemove, data.AuditEntityOrganization, org.ID,
+		envelope{"member": envelope{"user_id": userID}}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Organizations.RemoveMember(r.Context(), org.ID, userID, audit)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrLastOwner):
+			app.lastOwnerResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": i18n.Text(app.locale(r), "member_removed")}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"antipinegor/cyclingmarket/internal/data"
+	"antipinegor/cyclingmarket/internal/validator"
+	"context"
+	"errors"
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const outboxBatchSize = 50
+
+// deliverOutbox sends the due messages of the email outbox, failures are retried by later runs.
+func (app *application) deliverOutbox(ctx context.Context) error {
+	sent, failed, err := app.models.Outbox.DeliverDue(ctx, outboxBatchSize, func(message *data.OutboxMessage) error {
+		ctx, span := tracer.Start(ctx, "outbox.deliver", trace.WithAttributes(
+			attribute.Int64("outbox.message_id", message.ID),
+			attribute.Int("outbox.attempt", message.Attempts+1),
+			attribute.String("request.id", message.RequestID),
+		))
+		defer span.End()
+
+		err := app.mailer.Send(ctx, message.Recipient, message.Locale, message.Template, message.Data)
+		if err != nil {
+			app.metrics.mailSends.Inc(message.Template, "failed")
+			app.logger.Warn("sending email failed", "id", message.ID, "attempt", message.Attempts+1, "request_id", message.RequestID, "error", err.Error())
+			return err
+		}
+		app.metrics.mailSends.Inc(message.Template, "sent")
+		return nil
+	})
+	if sent > 0 || failed > 0 {
+		app.logger.Info("outbox delivered", "sent", sent, "failed", failed)
+	}
+	return err
+}
+
+func (app *application) listOutboxHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	queryString := r.URL.Query()
+
+	status := app.readString(queryString, "status", "")
+	if status != "" {
+		v.Check(validator.PermittedValue(status, data.OutboxStatuses...), "status", validator.OneOf(data.OutboxStatuses...))
+	}
+
+	filters := data.Filters{
+		Page:         app.readInt(queryString, "page", 1, v),
+		PageSize:     app.readInt(queryString, "page_size", 20, v),
+		Sort:         "id",
+		SortSafelist: []string{"id"},
+	}
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	messages, metadata, err := app.models.Outbox.GetAll(r.Context(), status, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"messages": messages, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// retryOutboxMessageHandler queues a failed message again right away.
+func (app *application) retryOutboxMessageHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	message, err := app.models.Outbox.Retry(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrOutboxMessageDead):
+			app.outboxMessageDeadResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": message}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -12,18 +12,58 @@ func (app *application) routes() http.Handler {
 	router.NotFound = http.HandlerFunc(app.notFoundResponse)
 	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
 
-	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthCheckHandler)
+	handle := func(method, pattern string, handler http.HandlerFunc) {
+		router.HandlerFunc(method, pattern, app.withRoute(pattern, handler))
+	}
 
-	router.HandlerFunc(http.MethodGet, "/v1/ads/:id", app.requirePermission("ads:read", app.showAdHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/ads", app.requirePermission("ads:read", app.showAdsHandler))
-	router.HandlerFunc(http.MethodPost, "/v1/ads", app.requirePermission("ads:write", app.postAdHandler))
-	router.HandlerFunc(http.MethodPatch, "/v1/ads/:id", app.requirePermission("ads:write", app.updateAdHandler))
-	router.HandlerFunc(http.MethodDelete, "/v1/ads/:id", app.requirePermission("ads:write", app.deleteAdHandler))
+	handle(http.MethodGet, "/v1/healthcheck", app.healthCheckHandler)
 
-	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
-	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
+	// listed ads are public like the storefronts, anonymous reads are what shared caches may store
+	handle(http.MethodGet, "/v1/ads/:id", app.showAdHandler)
+	handle(http.MethodGet, "/v1/ads", app.showAdsHandler)
+	handle(http.MethodPost, "/v1/ads", app.requirePermission("ads:write", app.idempotent(app.postAdHandler)))
+	handle(http.MethodPatch, "/v1/ads/:id", app.requirePermission("ads:write", app.updateAdHandler))
+	handle(http.MethodDelete, "/v1/ads/:id", app.requirePermission("ads:write", app.deleteAdHandler))
+	handle(http.MethodPost, "/v1/ads/:id/renew", app.requirePermission("ads:write", app.renewAdHandler))
+	handle(http.MethodPost, "/v1/ads/:id/restore", app.requirePermission("ads:write", app.restoreAdHandler))
+	handle(http.MethodGet, "/v1/ads/:id/price-history", app.requirePermission("ads:read", app.showPriceHistoryHandler))
+	handle(http.MethodPost, "/v1/ads/:id/images", app.requirePermission("ads:write", app.addAdImageHandler))
+	handle(http.MethodDelete, "/v1/ads/:id/images/:image_id", app.requirePermission("ads:write", app.deleteAdImageHandler))
 
-	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	handle(http.MethodGet, "/v1/favorites", app.requireActivatedUser(app.listFavoritesHandler))
+	handle(http.MethodPut, "/v1/favorites/:id", app.requireActivatedUser(app.putFavoriteHandler))
+	handle(http.MethodDelete, "/v1/favorites/:id", app.requireActivatedUser(app.deleteFavoriteHandler))
 
-	return app.recoverPanic(app.rateLimit(app.authenticate(router)))
+	handle(http.MethodPost, "/v1/imports/ads", app.requirePermission("ads:write", app.createAdImportHandler))
+	handle(http.MethodGet, "/v1/imports/ads/:id", app.requirePermission("ads:write", app.showAdImportHandler))
+	handle(http.MethodGet, "/v1/exports/ads", app.requirePermission("ads:read", app.exportAdsHandler))
+
+	handle(http.MethodGet, "/v1/search/suggest", app.requirePermission("ads:read", app.suggestHandler))
+	handle(http.MethodGet, "/v1/categories", app.listCategoriesHandler)
+
+	handle(http.MethodGet, "/v1/stolen/:serial", app.showStolenBikeHandler)
+	handle(http.MethodPost, "/v1/stolen", app.requireActivatedUser(app.reportStolenBikeHandler))
+	handle(http.MethodPost, "/v1/stolen/import", app.requirePermission("stolen:import", app.importStolenBikesHandler))
+	handle(http.MethodGet, "/v1/stolen-reports", app.requirePermission("stolen:moderate", app.listStolenReportsHandler))
+	handle(http.MethodPut, "/v1/stolen-reports/:id", app.requirePermission("stolen:moderate", app.reviewStolenReportHandler))
+
+	handle(http.MethodPost, "/v1/organizations", app.requireActivatedUser(app.createOrganizationHandler))
+	handle(http.MethodGet, "/v1/organizations/:slug", app.showStorefrontHandler)
+	handle(http.MethodPatch, "/v1/organizations/:slug", app.requireOrganizationPermission("org:update", app.updateOrganizationHandler))
+	handle(http.MethodGet, "/v1/organizations/:slug/members", app.requireOrganizationPermission("org:read", app.listMembersHandler))
+	handle(http.MethodPost, "/v1/organizations/:slug/members", app.requireOrganizationPermission("org:members", app.addMemberHandler))
+	handle(http.MethodDelete, "/v1/organizations/:slug/members/:user_id", app.requireOrganizationPermission("org:members", app.removeMemberHandler))
+
+	handle(http.MethodPost, "/v1/users", app.idempotent(app.registerUserHandler))
+	handle(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
+	handle(http.MethodPut, "/v1/users/locale", app.requireAuthenticatedUser(app.updateLocaleHandler))
+
+	handle(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+
+	handle(http.MethodGet, "/v1/audit-log", app.requirePermission("audit:read", app.listAuditLogHandler))
+
+	handle(http.MethodGet, "/v1/outbox", app.requirePermission("outbox:manage", app.listOutboxHandler))
+	handle(http.MethodPost, "/v1/outbox/:id/retry", app.requirePermission("outbox:manage", app.retryOutboxMessageHandler))
+
+	return app.logRequests(app.traceRequests(app.recoverPanic(app.rateLimit(app.authenticate(router)))))
 }
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"antipinegor/cyclingmarket/internal/data"
+	"antipinegor/cyclingmarket/internal/validator"
+	"net/http"
+	"strings"
+)
+
+func (app *application) suggestHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	queryString := r.URL.Query()
+	prefix := strings.TrimSpace(app.readString(queryString, "q", ""))
+	limit := app.readInt(queryString, "limit", 5, v)
+
+	data.ValidateSuggestQuery(v, prefix)
+	v.Check(limit > 0, "limit", validator.Positive)
+	v.Check(limit <= 10, "limit", validator.MaxValue(10))
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	suggestions, err := app.models.Suggestions.Suggest(r.Context(), prefix, limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"suggestions": suggestions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"antipinegor/cyclingmarket/internal/data"
+	"antipinegor/cyclingmarket/internal/validator"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+)
+
+var adIncludeSafelist = []string{"seller", "images"}
+
+type fieldset struct {
+	Fields  []string
+	Include []string
+}
+
+func (app *application) readFieldset(queryString url.Values, v *validator.Validator) fieldset {
+	fs := fieldset{
+		Fields:  app.readCSV(queryString, "fields", []string{}),
+		Include: app.readCSV(queryString, "include", []string{}),
+	}
+
+	v.Check(validator.PermittedValues(fs.Fields, data.AdFieldSafelist...), "fields", validator.OnlyOf(data.AdFieldSafelist...))
+	v.Check(validator.PermittedValues(fs.Include, adIncludeSafelist...), "include", validator.OnlyOf(adIncludeSafelist...))
+
+	return fs
+}
+
+func (fs fieldset) sparse() bool {
+	return len(fs.Fields) > 0 || len(fs.Include) > 0
+}
+
+// queryFields are the fields to read from the database: embedding the seller needs seller_id
+// even if the client didn't ask for it.
+func (fs fieldset) queryFields() []string {
+	if len(fs.Fields) == 0 {
+		return nil
+	}
+	fields := slices.Clone(fs.Fields)
+	if slices.Contains(fs.Include, "seller") {
+		fields = append(fields, "seller_id")
+	}
+	return fields
+}
+
+func (app *application) embedAdIncludes(r *http.Request, ads []*data.Ad, include []string) error {
+	if len(include) == 0 || len(ads) == 0 {
+		return nil
+	}
+
+	adIDs := make([]int64, 0, len(ads))
+	sellerIDs := make([]int64, 0, len(ads))
+	for _, ad := range ads {
+		adIDs = append(adIDs, ad.ID)
+		if ad.SellerID != 0 {
+			sellerIDs = append(sellerIDs, ad.SellerID)
+		}
+	}
+
+	if slices.Contains(include, "seller") {
+		sellers, err := app.models.Users.GetSellers(r.Context(), sellerIDs)
+		if err != nil {
+			return err
+		}
+		for _, ad := range ads {
+			ad.Seller = sellers[ad.SellerID]
+		}
+	}
+
+	if slices.Contains(include, "images") {
+		images, err := app.models.AdImages.GetForAds(r.Context(), adIDs)
+		if err != nil {
+			return err
+		}
+		for _, ad := range ads {
+			ad.Images = images[ad.ID]
+			if ad.Images == nil {
+				ad.Images = []*data.AdImage{}
+			}
+		}
+	}
+
+	return nil
+}
+
+// project drops every member the client didn't ask for from the JSON representation of an ad
+// or a list of ads. The columns were already left out of the query, this also hides the ones
+// that are always read.
+func (fs fieldset) project(value any) (any, error) {
+	if len(fs.Fields) == 0 {
+		return value, nil
+	}
+
+	keep := append(slices.Clone(fs.Fields), fs.Include...)
+
+	js, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := func(object map[string]json.RawMessage) {
+		for key := range object {
+			if !slices.Contains(keep, key) {
+				delete(object, key)
+			}
+		}
+	}
+
+	var list []map[string]json.RawMessage
+	if json.Unmarshal(js, &list) == nil {
+		for _, object := range list {
+			filter(object)
+		}
+		return list, nil
+	}
+
+	var object map[string]json.RawMessage
+	err = json.Unmarshal(js, &object)
+	if err != nil {
+		return nil, err
+	}
+	filter(object)
+	return object, nil
+}
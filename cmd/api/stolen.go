@@ -0,0 +1,262 @@
+package main
+
+import (
+	"antipinegor/cyclingmarket/internal/data"
+	"antipinegor/cyclingmarket/internal/validator"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func (app *application) checkStolenSerial(r *http.Request, v *validator.Validator, serial string) error {
+	if serial == "" {
+		return nil
+	}
+
+	stolen, err := app.models.StolenBikes.IsStolen(r.Context(), serial)
+	if err != nil {
+		return err
+	}
+
+	if stolen {
+		user := app.contextGetUser(r)
+		app.contextLogger(r).Warn("attempt to list a bike reported as stolen", "serial", serial, "user_id", user.ID, "uri", r.URL.RequestURI())
+		v.AddError("frame_serial", validator.NewMessage("stolen_frame_serial"))
+	}
+
+	return nil
+}
+
+func (app *application) showStolenBikeHandler(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	serial := data.NormalizeSerial(params.ByName("serial"))
+
+	v := validator.New()
+	if data.ValidateSerial(v, serial); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	bike, err := app.models.StolenBikes.GetBySerial(r.Context(), serial)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			err = app.writeJSON(w, http.StatusOK, envelope{"serial": serial, "stolen": false}, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+			}
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"serial": serial, "stolen": true, "report": bike}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// reportStolenBikeHandler files a report of a user. It only blocks listings once a moderator
+// approved it, until then the serial doesn't show up as stolen either.
+func (app *application) reportStolenBikeHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Serial      string `json:"serial"`
+		Description string `json:"description"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	bike := &data.StolenBike{
+		Serial:      data.NormalizeSerial(input.Serial),
+		Description: input.Description,
+		Source:      data.StolenSourceUser,
+		ReportedBy:  user.ID,
+	}
+
+	v := validator.New()
+	if data.ValidateStolenBike(v, bike); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.StolenBikes.Insert(r.Context(), bike)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateSerial):
+			v.AddError("serial", validator.NewMessage("duplicate_stolen_report"))
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"report": bike}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listStolenReportsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	queryString := r.URL.Query()
+
+	status := app.readString(queryString, "status", data.StolenStatusPending)
+	v.Check(validator.PermittedValue(status, data.StolenStatuses...), "status", validator.OneOf(data.StolenStatuses...))
+
+	filters := data.Filters{
+		Page:         app.readInt(queryString, "page", 1, v),
+		PageSize:     app.readInt(queryString, "page_size", 20, v),
+		Sort:         "id",
+		SortSafelist: []string{"id"},
+	}
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	reports, metadata, err := app.models.StolenBikes.GetAll(r.Context(), status, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"reports": reports, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// reviewStolenReportHandler approves or rejects a pending report. An approved report blocks
+// listings with the serial, a rejected one lets the serial be reported again.
+func (app *application) reviewStolenReportHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Status string `json:"status"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(validator.PermittedValue(input.Status, data.StolenStatusApproved, data.StolenStatusRejected), "status", validator.OneOf(data.StolenStatusApproved, data.StolenStatusRejected))
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	bike := &data.StolenBike{ID: id, Status: input.Status}
+	err = app.models.StolenBikes.Review(r.Context(), bike, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"report": bike}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// importStolenBikesHandler accepts a CSV list of stolen bikes, one per line: serial[,description].
+// A leading header line starting with "serial" is skipped. Invalid lines are reported with all
+// their errors by field.
+func (app *application) importStolenBikesHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 10_485_760)
+
+	reader := csv.NewReader(r.Body)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	var (
+		bikes   []*data.StolenBike
+		invalid = make(map[string]map[string]string)
+		seen    = make(map[string]bool)
+		locale  = app.locale(r)
+	)
+
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			var maxBytesError *http.MaxBytesError
+			if errors.As(err, &maxBytesError) {
+				app.badRequestResponse(w, r, newRequestError("body_too_large", maxBytesError.Limit))
+				return
+			}
+			app.badRequestResponse(w, r, fmt.Errorf("body contains badly-formed CSV: %w", err))
+			return
+		}
+
+		if line == 1 && strings.EqualFold(strings.TrimSpace(record[0]), "serial") {
+			continue
+		}
+
+		bike := &data.StolenBike{
+			Serial: data.NormalizeSerial(record[0]),
+			Source: data.StolenSourceImport,
+		}
+		if len(record) > 1 {
+			bike.Description = strings.TrimSpace(record[1])
+		}
+
+		v := validator.New()
+		if data.ValidateStolenBike(v, bike); !v.Valid() {
+			invalid[fmt.Sprintf("line %d", line)] = localizeErrors(locale, v.Errors)
+			continue
+		}
+
+		if seen[bike.Serial] {
+			continue
+		}
+		seen[bike.Serial] = true
+		bikes = append(bikes, bike)
+	}
+
+	if len(bikes) == 0 && len(invalid) == 0 {
+		app.badRequestResponse(w, r, newRequestError("body_empty"))
+		return
+	}
+
+	inserted, err := app.models.StolenBikes.InsertBatch(r.Context(), bikes)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	result := envelope{
+		"imported": inserted,
+		"skipped":  len(bikes) - inserted,
+		"invalid":  invalid,
+	}
+	err = app.writeJSON(w, http.StatusOK, result, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("antipinegor/cyclingmarket/cmd/api")
+
+// setupTracing installs the tracer provider selected by the otel-exporter flag. With "none" the
+// global provider stays a no-op and spans cost next to nothing. The otlp exporter is configured
+// with the standard OTEL_EXPORTER_OTLP_* environment variables. The returned function flushes
+// the spans that are still buffered.
+func setupTracing(cfg config) (func(context.Context) error, error) {
+	var exporter sdktrace.SpanExporter
+	var err error
+
+	switch cfg.otel.exporter {
+	case "none":
+		return func(context.Context) error { return nil }, nil
+	case "stdout":
+		exporter, err = stdouttrace.New()
+	case "otlp":
+		exporter, err = otlptracehttp.New(context.Background())
+	default:
+		return nil, fmt.Errorf("unknown otel exporter %q", cfg.otel.exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// attributes from OTEL_RESOURCE_ATTRIBUTES and OTEL_SERVICE_NAME override the defaults
+	res, err := resource.New(context.Background(),
+		resource.WithTelemetrySDK(),
+		resource.WithAttributes(
+			attribute.String("service.name", "cyclingmarket-api"),
+			attribute.String("service.version", version),
+			attribute.String("deployment.environment", cfg.state),
+		),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.otel.sampleRatio))),
+	)
+
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	return provider.Shutdown, nil
+}
+
+// traceRequests continues the trace of the W3C traceparent header, or starts a new one, and
+// records a server span for the request. The span is named after the route pattern once the
+// router has matched it. It runs inside logRequests, so the access log carries the trace ID.
+func (app *application) traceRequests(next http.Handler) http.Handler {
+	wrappedFunction := func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("url.path", r.URL.Path),
+				attribute.String("request.id", app.requestID(r)),
+			),
+		)
+		defer span.End()
+
+		info, ok := r.Context().Value(requestInfoContextKey).(*requestInfo)
+		if ok && span.SpanContext().IsValid() {
+			info.logger = info.logger.With("trace_id", span.SpanContext().TraceID().String())
+		}
+
+		lw := &loggingResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(lw, r.WithContext(ctx))
+
+		if ok && info.route != "" {
+			span.SetName(r.Method + " " + info.route)
+			span.SetAttributes(attribute.String("http.route", info.route))
+		}
+		if ok && info.userID != 0 {
+			span.SetAttributes(attribute.Int64("user.id", info.userID))
+		}
+		span.SetAttributes(attribute.Int("http.response.status_code", lw.status))
+		if lw.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, strconv.Itoa(lw.status))
+		}
+	}
+
+	return http.HandlerFunc(wrappedFunction)
+}
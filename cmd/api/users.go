@@ -13,6 +13,7 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		Name     string `json:"name"`
 		Email    string `json:"email"`
 		Password string `json:"password"`
+		Locale   string `json:"locale"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -25,9 +26,13 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		Name:      input.Name,
 		Email:     input.Email,
 		Activated: false,
+		Locale:    input.Locale,
+	}
+	if user.Locale == "" {
+		user.Locale = data.DefaultLocale
 	}
 
-	err = user.Password.Set(input.Password)
+	err = user.Password.Set(r.Context(), input.Password)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -39,11 +44,30 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	err = app.models.Users.Insert(user)
+	audit, err := app.newAuditEntry(r, data.AuditUserRegister, data.AuditEntityUser, 0, nil,
+		envelope{"user": user, "permissions": []string{"ads:read"}})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	_, err = app.models.Users.Register(r.Context(), user, []string{"ads:read"}, 3*24*time.Hour, audit, func(token *data.Token) *data.OutboxMessage {
+		return &data.OutboxMessage{
+			Recipient: user.Email,
+			Locale:    user.Locale,
+			Template:  "user_welcome.tmpl",
+			RequestID: app.requestID(r),
+			Data: map[string]any{
+				"activationToken": token.Plaintext,
+				"userID":          user.ID,
+				"username":        user.Name,
+			},
+		}
+	})
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrDuplicateEmail):
-			v.AddError("email", "user with this email address already exists")
+			v.AddError("email", validator.NewMessage("duplicate_email"))
 			app.failedValidationResponse(w, r, v.Errors)
 		default:
 			app.serverErrorResponse(w, r, err)
@@ -51,32 +75,6 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	err = app.models.Permissions.AddForUser(user.ID, "ads:read")
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
-	}
-
-	token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
-	}
-
-	app.background(func() {
-
-		data := map[string]any{
-			"activationToken": token.Plaintext,
-			"userID":          user.ID,
-			"username":        user.Name,
-		}
-
-		err = app.mailer.Send(user.Email, "user_welcome.tmpl", data)
-		if err != nil {
-			app.logger.Error(err.Error())
-		}
-	})
-
 	err = app.writeJSON(w, http.StatusAccepted, envelope{"user": user}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -96,16 +94,18 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 
 	v := validator.New()
 	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.metrics.tokenFailures.Inc(data.ScopeActivation, "malformed")
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	user, err := app.models.Users.GetForToken(data.ScopeActivation, input.TokenPlaintext)
+	user, err := app.models.Users.GetForToken(r.Context(), data.ScopeActivation, input.TokenPlaintext)
 
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
-			v.AddError("token", "invalid or expired activation token")
+			app.metrics.tokenFailures.Inc(data.ScopeActivation, "invalid")
+			v.AddError("token", validator.NewMessage("invalid_activation_token"))
 			app.failedValidationResponse(w, r, v.Errors)
 		default:
 			app.serverErrorResponse(w, r, err)
@@ -113,9 +113,17 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	// the request is anonymous, the activation token identifies the actor
+	audit, err := app.newAuditEntry(r, data.AuditUserActivate, data.AuditEntityUser, user.ID, envelope{"activated": user.Activated}, envelope{"activated": true})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	audit.ActorID = user.ID
+
 	user.Activated = true
 
-	err = app.models.Users.Update(user)
+	err = app.models.Users.Update(r.Context(), user, audit)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -126,7 +134,7 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	err = app.models.Tokens.DeleteAllForUser(data.ScopeActivation, user.ID)
+	err = app.models.Tokens.DeleteAllForUser(r.Context(), data.ScopeActivation, user.ID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -137,3 +145,48 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// updateLocaleHandler sets the language of the emails the user receives.
+func (app *application) updateLocaleHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Locale string `json:"locale"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateLocale(v, input.Locale); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	original := *user
+	user.Locale = input.Locale
+
+	audit, err := app.newAuditEntry(r, data.AuditUserUpdate, data.AuditEntityUser, user.ID, &original, user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Users.Update(r.Context(), user, audit)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
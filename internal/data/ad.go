@@ -6,83 +6,356 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/lib/pq"
 )
 
+const (
+	ConditionNew      = "new"
+	ConditionLikeNew  = "like-new"
+	ConditionUsed     = "used"
+	ConditionForParts = "for-parts"
+)
+
+var Conditions = []string{ConditionNew, ConditionLikeNew, ConditionUsed, ConditionForParts}
+
+// Ad is patched generically through its JSON representation, so writable fields must not be
+// omitempty: JSON patch can only replace members that are present. Snippet is HTML, an escaped
+// excerpt of the description with the search matches in <mark> tags.
 type Ad struct {
-	ID          int64     `json:"id"`
-	CreatedAt   time.Time `json:"-"`
-	Title       string    `json:"title"`
-	Description string    `json:"description"`
-	Categories  []string  `json:"categories"`
-	Price       Price     `json:"price"`
-	Version     int32     `json:"version"`
+	ID             int64      `json:"id"`
+	CreatedAt      time.Time  `json:"-"`
+	UpdatedAt      time.Time  `json:"-"`
+	Title          string     `json:"title"`
+	Description    string     `json:"description"`
+	Categories     []string   `json:"categories"`
+	Price          Price      `json:"price"`
+	PriceReducedBy int        `json:"price_reduced_by,omitempty"`
+	Condition      string     `json:"condition"`
+	FrameSerial    string     `json:"frame_serial"`
+	SellerID       int64      `json:"seller_id,omitempty"`
+	OrganizationID int64      `json:"organization_id,omitempty"`
+	ExpiresAt      time.Time  `json:"expires_at"`
+	Version        int32      `json:"version"`
+	Snippet        string     `json:"snippet,omitempty"`
+	Seller         *Seller    `json:"seller,omitempty"`
+	Images         []*AdImage `json:"images,omitempty"`
 }
 
-func ValidateAd(v *validator.Validator, ad *Ad) {
-	v.Check(ad.Title != "", "title", "must be provided")
-	v.Check(len(ad.Title) <= 80, "title", "must not be more than 80 bytes long")
+// AdReadOnlyFields are the fields of an ad a PATCH request must leave as they are.
+var AdReadOnlyFields = []string{"id", "price_reduced_by", "seller_id", "organization_id", "expires_at", "snippet", "seller", "images"}
 
-	v.Check(ad.Description != "", "description", "must be provided")
-	v.Check(len(ad.Description) <= 500, "description", "must not be more than 500 bytes long")
+var AdFieldSafelist = []string{"id", "title", "description", "categories", "price", "price_reduced_by", "condition", "frame_serial", "seller_id", "organization_id", "expires_at", "version", "snippet"}
 
-	v.Check(ad.Price != 0, "price", "must be provided")
-	v.Check(ad.Price > 0, "price", "must be positive integer")
+type adColumn struct {
+	field      string
+	expression string
+	dest       func(*Ad) any
+}
 
-	v.Check(ad.Categories != nil, "categories", "must be provided")
-	v.Check(len(ad.Categories) >= 1, "categories", "must contain at least 1 categories")
-	v.Check(len(ad.Categories) <= 5, "categories", "must not contain more than 5 categories")
-	v.Check(validator.Unique(ad.Categories), "categories", "must not contain duplicate values")
+// adsPriceReducedBy is the last price drop of an ad in whole percent, zero if the price was never reduced.
+const adsPriceReducedBy = `case when previous_price > price then floor(100.0 * (previous_price - price) / previous_price)::integer else 0 end`
+
+// adColumns lists the columns an ad is read from, keyed by the JSON field they fill,
+// so that a sparse fieldset only fetches what the client asked for.
+var adColumns = []adColumn{
+	{"id", "id", func(a *Ad) any { return &a.ID }},
+	{"created_at", "created_at", func(a *Ad) any { return &a.CreatedAt }},
+	{"updated_at", "updated_at", func(a *Ad) any { return &a.UpdatedAt }},
+	{"title", "title", func(a *Ad) any { return &a.Title }},
+	{"description", "description", func(a *Ad) any { return &a.Description }},
+	{"price", "price", func(a *Ad) any { return &a.Price }},
+	{"price_reduced_by", adsPriceReducedBy, func(a *Ad) any { return &a.PriceReducedBy }},
+	{"categories", "categories", func(a *Ad) any { return pq.Array(&a.Categories) }},
+	{"condition", "condition", func(a *Ad) any { return &a.Condition }},
+	{"frame_serial", "frame_serial", func(a *Ad) any { return &a.FrameSerial }},
+	{"seller_id", "coalesce(user_id, 0)", func(a *Ad) any { return &a.SellerID }},
+	{"organization_id", "coalesce(organization_id, 0)", func(a *Ad) any { return &a.OrganizationID }},
+	{"expires_at", "expires_at", func(a *Ad) any { return &a.ExpiresAt }},
+	{"version", "version", func(a *Ad) any { return &a.Version }},
+}
+
+// selectAdColumns picks the columns for the requested fields, all of them if fields is empty.
+// Id, timestamps and version are always read as they back ETags and cursors.
+func selectAdColumns(fields []string, extra ...string) []adColumn {
+	if len(fields) == 0 {
+		return adColumns
+	}
+
+	selected := []adColumn{}
+	for _, column := range adColumns {
+		switch {
+		case column.field == "id" || column.field == "created_at" || column.field == "updated_at" || column.field == "version",
+			slices.Contains(fields, column.field),
+			slices.Contains(extra, column.field):
+			selected = append(selected, column)
+		}
+	}
+	return selected
+}
+
+func ValidateAd(v *validator.Validator, ad *Ad, tree *CategoryTree) {
+	v.Check(ad.Title != "", "title", validator.Required)
+	v.Check(len(ad.Title) <= 80, "title", validator.MaxBytes(80))
+
+	v.Check(ad.Description != "", "description", validator.Required)
+	v.Check(len(ad.Description) <= 500, "description", validator.MaxBytes(500))
+
+	v.Check(ad.Price != 0, "price", validator.Required)
+	v.Check(ad.Price > 0, "price", validator.Positive)
+
+	v.Check(ad.Categories != nil, "categories", validator.Required)
+	v.Check(len(ad.Categories) >= 1, "categories", validator.MinItems(1))
+	v.Check(len(ad.Categories) <= 5, "categories", validator.MaxItems(5))
+	v.Check(validator.Unique(ad.Categories), "categories", validator.NoDuplicates)
+	ValidateCategories(v, "categories", ad.Categories, tree)
+
+	v.Check(validator.PermittedValue(ad.Condition, Conditions...), "condition", validator.OneOf(Conditions...))
+
+	if ad.FrameSerial != "" {
+		v.Check(len(ad.FrameSerial) >= 4, "frame_serial", validator.MinChars(4))
+		v.Check(len(ad.FrameSerial) <= 64, "frame_serial", validator.MaxBytes(64))
+	}
 }
 
 type AdModel struct {
 	DB *sql.DB
 }
 
-func (ad AdModel) Insert(adToInsert *Ad) error {
+func (ad AdModel) Insert(ctx context.Context, adToInsert *Ad, audit *AuditEntry) error {
+	query := `
+		with inserted as (
+			insert 
+			into ads (title, description, price, categories, condition, frame_serial, user_id, organization_id, expires_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $8, now() + $9 * interval '1 second')
+			returning id, created_at, updated_at, expires_at, version, price
+		), history as (
+			insert into ad_price_history (ad_id, price, changed_at)
+			select id, price, created_at from inserted
+		)
+		select id, created_at, updated_at, expires_at, version from inserted
+	`
+	args := []any{adToInsert.Title, adToInsert.Description, adToInsert.Price, pq.Array(adToInsert.Categories), adToInsert.Condition, adToInsert.FrameSerial, nullInt64(adToInsert.SellerID), nullInt64(adToInsert.OrganizationID), int64(AdLifetime.Seconds())}
+
+	ctx, cancel := queryContext(ctx, "AdModel.Insert", 3*time.Second)
+	defer cancel()
+
+	return inTransaction(ctx, ad.DB, audit, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, query, args...).Scan(&adToInsert.ID, &adToInsert.CreatedAt, &adToInsert.UpdatedAt, &adToInsert.ExpiresAt, &adToInsert.Version)
+		if err != nil {
+			return err
+		}
+		if audit != nil {
+			audit.EntityID = adToInsert.ID
+		}
+		return nil
+	})
+}
+
+// InsertBatch inserts all ads in a single transaction, either every ad is stored or none is.
+// Every ad is logged with a copy of the audit entry that gets the id and the fields of the ad.
+func (ad AdModel) InsertBatch(ctx context.Context, ads []*Ad, audit *AuditEntry) error {
 	query := `
-		insert 
-		into ads (title, description, price, categories)
-		values ($1, $2, $3, $4)
-		returning id, created_at, version
+		with inserted as (
+			insert 
+			into ads (title, description, price, categories, condition, frame_serial, user_id, organization_id, expires_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $8, now() + $9 * interval '1 second')
+			returning id, created_at, updated_at, expires_at, version, price
+		), history as (
+			insert into ad_price_history (ad_id, price, changed_at)
+			select id, price, created_at from inserted
+		)
+		select id, created_at, updated_at, expires_at, version from inserted
 	`
-	args := []any{adToInsert.Title, adToInsert.Description, adToInsert.Price, pq.Array(adToInsert.Categories)}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(ctx, "AdModel.InsertBatch", 30*time.Second)
 	defer cancel()
 
-	return ad.DB.QueryRowContext(ctx, query, args...).Scan(&adToInsert.ID, &adToInsert.CreatedAt, &adToInsert.Version)
+	tx, err := ad.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, adToInsert := range ads {
+		args := []any{adToInsert.Title, adToInsert.Description, adToInsert.Price, pq.Array(adToInsert.Categories), adToInsert.Condition, adToInsert.FrameSerial, nullInt64(adToInsert.SellerID), nullInt64(adToInsert.OrganizationID), int64(AdLifetime.Seconds())}
+		err := stmt.QueryRowContext(ctx, args...).Scan(&adToInsert.ID, &adToInsert.CreatedAt, &adToInsert.UpdatedAt, &adToInsert.ExpiresAt, &adToInsert.Version)
+		if err != nil {
+			return err
+		}
+
+		if audit != nil {
+			entry := *audit
+			entry.EntityID = adToInsert.ID
+			err = entry.Track(nil, adToInsert)
+			if err != nil {
+				return err
+			}
+			err = insertAuditEntry(ctx, tx, &entry)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ForEachBySeller streams every ad of a seller to fn without loading them all into memory,
+// iteration stops at the first error returned by fn. The timeout covers the whole iteration,
+// so it has to leave time for fn as well.
+func (ad AdModel) ForEachBySeller(ctx context.Context, sellerID int64, timeout time.Duration, fn func(*Ad) error) error {
+	columns := selectAdColumns(nil)
+	expressions := make([]string, len(columns))
+	for i, column := range columns {
+		expressions[i] = column.expression
+	}
+
+	query := fmt.Sprintf(`
+		select
+			%s
+		from
+			ads
+		where
+			user_id = $1 and deleted_at is null
+		order by
+			id
+	`, strings.Join(expressions, ", "))
+
+	ctx, cancel := queryContext(ctx, "AdModel.ForEachBySeller", timeout)
+	defer cancel()
+
+	rows, err := ad.DB.QueryContext(ctx, query, sellerID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var adResponse Ad
+		dest := make([]any, len(columns))
+		for i, column := range columns {
+			dest[i] = column.dest(&adResponse)
+		}
+
+		err := rows.Scan(dest...)
+		if err != nil {
+			return err
+		}
+
+		err = fn(&adResponse)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// GetAllForOrganization returns a page of the ads an organization owns, for its storefront.
+func (ad AdModel) GetAllForOrganization(ctx context.Context, organizationID int64, filters Filters) ([]*Ad, Metadata, error) {
+	columns := selectAdColumns(nil)
+	expressions := make([]string, len(columns))
+	for i, column := range columns {
+		expressions[i] = column.expression
+	}
+
+	query := fmt.Sprintf(`
+		select
+			count(*) over(), %s
+		from
+			ads
+		where
+			organization_id = $1 and expires_at > now() and deleted_at is null
+		order by
+			%s %s, id ASC
+		limit $2 offset $3
+	`, strings.Join(expressions, ", "), filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := queryContext(ctx, "AdModel.GetAllForOrganization", 3*time.Second)
+	defer cancel()
+
+	rows, err := ad.DB.QueryContext(ctx, query, organizationID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	ads := []*Ad{}
+
+	for rows.Next() {
+		var adResponse Ad
+
+		dest := []any{&totalRecords}
+		for _, column := range columns {
+			dest = append(dest, column.dest(&adResponse))
+		}
+
+		err := rows.Scan(dest...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		ads = append(ads, &adResponse)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return ads, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
 }
 
-func (ad AdModel) GetById(id int64) (*Ad, error) {
+func (ad AdModel) GetById(ctx context.Context, id int64) (*Ad, error) {
+	return ad.Get(ctx, id, nil)
+}
+
+func (ad AdModel) Get(ctx context.Context, id int64, fields []string) (*Ad, error) {
+	return ad.get(ctx, id, fields, "deleted_at is null")
+}
+
+// GetDeleted returns an ad that was deleted, but not purged yet.
+func (ad AdModel) GetDeleted(ctx context.Context, id int64) (*Ad, error) {
+	return ad.get(ctx, id, nil, "deleted_at is not null")
+}
+
+func (ad AdModel) get(ctx context.Context, id int64, fields []string, condition string) (*Ad, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
-	query := `
+
+	var adResponse Ad
+	columns := selectAdColumns(fields)
+	expressions := make([]string, len(columns))
+	dest := make([]any, len(columns))
+	for i, column := range columns {
+		expressions[i] = column.expression
+		dest[i] = column.dest(&adResponse)
+	}
+
+	query := fmt.Sprintf(`
 		select 
-			id, created_at, title, description, price, categories, version
+			%s
 		from 
 			ads
 		where
-			id = $1
-	`
-	var adResponse Ad
+			id = $1 and %s
+	`, strings.Join(expressions, ", "), condition)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(ctx, "AdModel.get", 3*time.Second)
 	defer cancel()
 
-	err := ad.DB.QueryRowContext(ctx, query, id).Scan(
-		&adResponse.ID,
-		&adResponse.CreatedAt,
-		&adResponse.Title,
-		&adResponse.Description,
-		&adResponse.Price,
-		pq.Array(&adResponse.Categories),
-		&adResponse.Version,
-	)
-
+	err := ad.DB.QueryRowContext(ctx, query, id).Scan(dest...)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -95,27 +368,146 @@ func (ad AdModel) GetById(id int64) (*Ad, error) {
 	return &adResponse, nil
 }
 
-func (ad AdModel) GetAll(title string, categories []string, filters Filters) ([]*Ad, Metadata, error) {
+// adsRequestedCategories and adsFilter select ads by the text search ($1) and requested categories ($2).
+// The text is searched in titles and descriptions with russian and english stemming, a fuzzy match on
+// the title keeps typos in brand names findable. Every requested category matches an ad that has this
+// category or any of its descendants. Expired and deleted ads are never listed.
+const (
+	adsSearchQuery = `(websearch_to_tsquery('russian', $1) || websearch_to_tsquery('english', $1))`
+
+	adsRelevance = `
+			case when $1 = '' then 0
+			else ts_rank_cd(search_vector, ` + adsSearchQuery + `) + word_similarity($1, title)
+			end`
+
+	adsRequestedCategories = `
+		requested(root, id, slug) as (
+			select slug, id, slug from categories where slug = any($2)
+			union all
+			select requested.root, categories.id, categories.slug
+			from categories
+			inner join requested on categories.parent_id = requested.id
+		)`
+
+	adsFilter = `
+			expires_at > now() and deleted_at is null
+			and
+			($1 = '' or search_vector @@ ` + adsSearchQuery + ` or $1 <% title)
+			and
+			(cardinality($2::text[]) = 0 or categories && (select array_agg(slug) from requested))
+			and
+			(select count(distinct root) from requested where requested.slug = any(ads.categories)) = cardinality($2::text[])`
+)
+
+func (ad AdModel) GetAll(ctx context.Context, title string, categories []string, fields []string, filters Filters) ([]*Ad, Metadata, error) {
+	return ad.getAll(ctx, ad.DB, title, categories, fields, filters)
+}
+
+// GetAllWithFacets works like GetAll and counts the requested facets of the same filter. Both
+// queries run in one read-only repeatable read transaction, so the counts always describe the
+// snapshot the page was read from.
+func (ad AdModel) GetAllWithFacets(ctx context.Context, title string, categories []string, fields []string, filters Filters, facets []string) ([]*Ad, Metadata, Facets, error) {
+	ctx, cancel := queryContext(ctx, "AdModel.GetAllWithFacets", 6*time.Second)
+	defer cancel()
+
+	tx, err := ad.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, Metadata{}, Facets{}, err
+	}
+	defer tx.Rollback()
+
+	ads, metadata, err := ad.getAll(ctx, tx, title, categories, fields, filters)
+	if err != nil {
+		return nil, Metadata{}, Facets{}, err
+	}
+
+	counts, err := ad.getFacets(ctx, tx, title, categories, facets)
+	if err != nil {
+		return nil, Metadata{}, Facets{}, err
+	}
+
+	return ads, metadata, counts, tx.Commit()
+}
+
+// querier is what getAll and getFacets need, they run on the pool or in a transaction.
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+func (ad AdModel) getAll(ctx context.Context, q querier, title string, categories []string, fields []string, filters Filters) ([]*Ad, Metadata, error) {
+	withSnippet := len(fields) == 0 || slices.Contains(fields, "snippet")
+
+	// the sort column is needed for ordering and cursors, the description for snippets
+	extra := []string{filters.sortColumn()}
+	if withSnippet {
+		extra = append(extra, "description")
+	}
+	columns := selectAdColumns(fields, extra...)
+
+	innerColumns := make([]string, len(columns))
+	outerColumns := make([]string, len(columns))
+	for i, column := range columns {
+		innerColumns[i] = column.expression + " as " + column.field
+		outerColumns[i] = column.field
+	}
+
+	// the snippet is HTML: the description is escaped before the matches are wrapped in <mark>,
+	// markup written by the seller must not reach the clients that render the snippet
+	snippet := "''"
+	if withSnippet {
+		snippet = fmt.Sprintf(`
+			case when $1 = '' then ''
+			else ts_headline('russian', %s, %s, 'StartSel=<mark>, StopSel=</mark>, MaxFragments=2, MinWords=5, MaxWords=20')
+			end`, escapeHTML("description"), adsSearchQuery)
+	}
+
+	// with a cursor the window would only count the rows after it, the total is counted apart
+	total := "0"
+	switch {
+	case filters.IncludeTotal && filters.Cursor != nil:
+		total = "(select count(*) from ads where " + adsFilter + ")"
+	case filters.IncludeTotal:
+		total = "count(*) over()"
+	}
+
+	args := []any{title, pq.Array(categories), filters.limit() + 1, filters.offset()}
+
+	keyset := "true"
+	if filters.Cursor != nil {
+		expression, sqlType := adsSortKey(filters.sortColumn())
+		keyset = fmt.Sprintf("(%[1]s %[2]s $5::%[3]s or (%[1]s = $5::%[3]s and id > $6))", expression, filters.keysetOperator(), sqlType)
+		args = append(args, filters.Cursor.Value, filters.Cursor.ID)
+	}
+
+	// one row more than the page size is fetched to find out whether there is a next page,
+	// snippets are highlighted in the outer query, so ts_headline only runs for the rows of the page
 	query := fmt.Sprintf(`
+		with recursive %[1]s
 		select
-			count(*) over(), id, created_at, title, description, price, categories, version
-		from
-			ads
-		where
-			(to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '') 
-			and
-			(categories @> $2 or $2 = '{}')
+			total, %[9]s, %[2]s, relevance
+		from (
+			select
+				%[7]s as total, %[10]s,
+				%[3]s as relevance
+			from
+				ads
+			where
+				%[4]s
+				and
+				%[8]s
+			order by
+				%[5]s %[6]s, id ASC
+			limit $3 offset $4
+		) as page
 		order by
-			%s %s, id ASC
-		limit $3 offset $4
-	`, filters.sortColumn(), filters.sortDirection())
+			%[5]s %[6]s, id ASC
+	`, adsRequestedCategories, snippet, adsRelevance, adsFilter, filters.sortColumn(), filters.sortDirection(), total, keyset,
+		strings.Join(outerColumns, ", "), strings.Join(innerColumns, ", "))
 
-	args := []any{title, pq.Array(categories), filters.limit(), filters.offset()}
-
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(ctx, "AdModel.GetAll", 3*time.Second)
 	defer cancel()
 
-	rows, err := ad.DB.QueryContext(ctx, query, args...)
+	rows, err := q.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, Metadata{}, err
 	}
@@ -123,95 +515,232 @@ func (ad AdModel) GetAll(title string, categories []string, filters Filters) ([]
 
 	totalRecords := 0
 	ads := []*Ad{}
+	relevances := []float32{}
 
 	for rows.Next() {
 		var adResponse Ad
-		err := rows.Scan(
-			&totalRecords,
-			&adResponse.ID,
-			&adResponse.CreatedAt,
-			&adResponse.Title,
-			&adResponse.Description,
-			&adResponse.Price,
-			pq.Array(&adResponse.Categories),
-			&adResponse.Version,
-		)
+		var relevance float32
+
+		dest := []any{&totalRecords}
+		for _, column := range columns {
+			dest = append(dest, column.dest(&adResponse))
+		}
+		dest = append(dest, &adResponse.Snippet, &relevance)
+
+		err := rows.Scan(dest...)
 
 		if err != nil {
 			return nil, Metadata{}, err
 		}
 		ads = append(ads, &adResponse)
+		relevances = append(relevances, relevance)
 	}
 
 	if err = rows.Err(); err != nil {
 		return nil, Metadata{}, err
 	}
 
-	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	var metadata Metadata
+	switch {
+	case filters.IncludeTotal && filters.Cursor == nil:
+		metadata = calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	case filters.IncludeTotal:
+		metadata = Metadata{PageSize: filters.PageSize, TotalRecords: totalRecords}
+	case filters.Cursor == nil:
+		metadata = Metadata{CurrentPage: filters.Page, PageSize: filters.PageSize}
+	default:
+		metadata = Metadata{PageSize: filters.PageSize}
+	}
+
+	if len(ads) > filters.limit() {
+		ads = ads[:filters.limit()]
+		last := ads[len(ads)-1]
+
+		cursor := Cursor{Sort: filters.Sort, ID: last.ID}
+		switch filters.sortColumn() {
+		case "id":
+			cursor.Value = strconv.FormatInt(last.ID, 10)
+		case "title":
+			cursor.Value = last.Title
+		case "price":
+			cursor.Value = strconv.FormatInt(int64(last.Price), 10)
+		case "relevance":
+			cursor.Value = strconv.FormatFloat(float64(relevances[len(ads)-1]), 'g', -1, 32)
+		}
+		metadata.NextCursor = cursor.Encode()
+	}
 
 	return ads, metadata, nil
 }
 
-func (ad AdModel) Update(adToUpdate *Ad) error {
+// adsSortKey returns the SQL expression and type of a sort column, used to compare rows with a cursor.
+func adsSortKey(column string) (string, string) {
+	switch column {
+	case "title":
+		return "title", "text"
+	case "price":
+		return "price", "integer"
+	case "relevance":
+		return adsRelevance, "real"
+	default:
+		return "id", "bigint"
+	}
+}
+
+// Update saves the ad if it still has the version it was read with. A changed price is
+// recorded in the price history in the same transaction, and if it dropped, the messages
+// built by notify for the users who favorited the ad are queued in it too.
+func (ad AdModel) Update(ctx context.Context, adToUpdate *Ad, audit *AuditEntry, notify func(*PriceDropWatcher) *OutboxMessage) error {
 	query := `
 		update 
 			ads
 		set 
-			title = $1, description = $2, price = $3, categories = $4, version = version + 1
+			title = $1, description = $2, price = $3, categories = $4, condition = $5, frame_serial = $6,
+			previous_price = case when price <> $3 then price else previous_price end,
+			updated_at = now(), version = version + 1
 		where 
-			id = $5 and version = $6
-		returning version
+			id = $7 and version = $8
+		returning updated_at, version, ` + adsPriceReducedBy + `
 	`
 	args := []any{
 		adToUpdate.Title,
 		adToUpdate.Description,
 		adToUpdate.Price,
 		pq.Array(adToUpdate.Categories),
+		adToUpdate.Condition,
+		adToUpdate.FrameSerial,
 		adToUpdate.ID,
 		adToUpdate.Version,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(ctx, "AdModel.Update", 3*time.Second)
 	defer cancel()
 
-	err := ad.DB.QueryRowContext(ctx, query, args...).Scan(&adToUpdate.Version)
-	if err != nil {
-		switch {
-		case errors.Is(err, sql.ErrNoRows):
-			return ErrEditConflict
-		default:
-			return err
+	return inTransaction(ctx, ad.DB, audit, func(tx *sql.Tx) error {
+		var oldPrice Price
+		err := tx.QueryRowContext(ctx, `select price from ads where id = $1 and version = $2 and deleted_at is null for update`, adToUpdate.ID, adToUpdate.Version).Scan(&oldPrice)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrEditConflict
+			default:
+				return err
+			}
+		}
+
+		err = tx.QueryRowContext(ctx, query, args...).Scan(&adToUpdate.UpdatedAt, &adToUpdate.Version, &adToUpdate.PriceReducedBy)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrEditConflict
+			default:
+				return err
+			}
+		}
+
+		if oldPrice != adToUpdate.Price {
+			_, err = tx.ExecContext(ctx, `
+				insert into ad_price_history (ad_id, price, changed_at)
+				values ($1, $2, $3)
+			`, adToUpdate.ID, adToUpdate.Price, adToUpdate.UpdatedAt)
+			if err != nil {
+				return err
+			}
+		}
+
+		if adToUpdate.Price < oldPrice {
+			return notifyPriceDrop(ctx, tx, adToUpdate.ID, adToUpdate.Price, notify)
 		}
-	}
 
-	return nil
+		return nil
+	})
 }
 
-func (ad AdModel) Delete(id int64) error {
+// Delete only marks the ad as deleted, it can be restored within AdRestoreWindow
+// and is purged for good by PurgeDeleted.
+func (ad AdModel) Delete(ctx context.Context, id int64, audit *AuditEntry) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 
 	query := `
-		delete 
-			from ads
+		update
+			ads
+		set
+			deleted_at = now()
 		where 
-			id = $1
+			id = $1 and deleted_at is null
 	`
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(ctx, "AdModel.Delete", 3*time.Second)
 	defer cancel()
 
-	result, err := ad.DB.ExecContext(ctx, query, id)
-	if err != nil {
-		return err
-	}
-	rowsAffected, err := result.RowsAffected()
+	return inTransaction(ctx, ad.DB, audit, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, query, id)
+		if err != nil {
+			return err
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rowsAffected == 0 {
+			return ErrRecordNotFound
+		}
+		return nil
+	})
+}
+
+// Restore undoes Delete, unless the ad was deleted more than AdRestoreWindow ago.
+func (ad AdModel) Restore(ctx context.Context, adToRestore *Ad, audit *AuditEntry) error {
+	query := `
+		update
+			ads
+		set
+			deleted_at = null, updated_at = now(), version = version + 1
+		where
+			id = $1 and deleted_at > now() - $2 * interval '1 second'
+		returning updated_at, version
+	`
+
+	ctx, cancel := queryContext(ctx, "AdModel.Restore", 3*time.Second)
+	defer cancel()
+
+	return inTransaction(ctx, ad.DB, audit, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, query, adToRestore.ID, int64(AdRestoreWindow.Seconds())).Scan(&adToRestore.UpdatedAt, &adToRestore.Version)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrRecordNotFound
+			default:
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// PurgeDeleted permanently removes ads deleted more than retention ago.
+func (ad AdModel) PurgeDeleted(ctx context.Context, retention time.Duration) (int64, error) {
+	query := `
+		delete from ads
+		where deleted_at < now() - $1 * interval '1 second'
+	`
+
+	ctx, cancel := queryContext(ctx, "AdModel.PurgeDeleted", 30*time.Second)
+	defer cancel()
+
+	result, err := ad.DB.ExecContext(ctx, query, int64(retention.Seconds()))
 	if err != nil {
-		return err
-	}
-	if rowsAffected == 0 {
-		return ErrRecordNotFound
+		return 0, err
 	}
+	return result.RowsAffected()
+}
 
-	return nil
+// escapeHTML returns an SQL expression that escapes the text of column for use in HTML.
+func escapeHTML(column string) string {
+	expression := column
+	for _, r := range []struct{ from, to string }{{"&", "&amp;"}, {"<", "&lt;"}, {">", "&gt;"}, {`"`, "&quot;"}, {"'", "&#39;"}} {
+		expression = fmt.Sprintf("replace(%s, '%s', '%s')", expression, strings.ReplaceAll(r.from, "'", "''"), r.to)
+	}
+	return expression
 }
@@ -0,0 +1,228 @@
+package data
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+const (
+	AuditAdCreate                 = "ad.create"
+	AuditAdUpdate                 = "ad.update"
+	AuditAdDelete                 = "ad.delete"
+	AuditAdRestore                = "ad.restore"
+	AuditAdRenew                  = "ad.renew"
+	AuditAdImageAdd               = "ad.image_add"
+	AuditAdImageRemove            = "ad.image_remove"
+	AuditUserRegister             = "user.register"
+	AuditUserActivate             = "user.activate"
+	AuditUserUpdate               = "user.update"
+	AuditPermissionGrant          = "permission.grant"
+	AuditOrganizationCreate       = "organization.create"
+	AuditOrganizationUpdate       = "organization.update"
+	AuditOrganizationMemberAdd    = "organization.member_add"
+	AuditOrganizationMemberRemove = "organization.member_remove"
+
+	AuditEntityAd           = "ad"
+	AuditEntityUser         = "user"
+	AuditEntityOrganization = "organization"
+)
+
+var AuditEntities = []string{AuditEntityAd, AuditEntityUser, AuditEntityOrganization}
+
+type AuditChange struct {
+	Before json.RawMessage `json:"before,omitempty"`
+	After  json.RawMessage `json:"after,omitempty"`
+}
+
+type AuditEntry struct {
+	ID        int64                  `json:"id"`
+	CreatedAt time.Time              `json:"created_at"`
+	ActorID   int64                  `json:"actor_id,omitempty"`
+	IP        string                 `json:"ip"`
+	Action    string                 `json:"action"`
+	Entity    string                 `json:"entity"`
+	EntityID  int64                  `json:"entity_id"`
+	Changes   map[string]AuditChange `json:"changes"`
+	RequestID string                 `json:"request_id,omitempty"`
+
+	before map[string]json.RawMessage
+	after  any
+}
+
+// Track sets the states of the entity the entry compares, nil before for created entities and
+// nil after for deleted ones. Before is serialized right away, after only when the entry is
+// written, so it can point to the entity the model is about to change.
+func (e *AuditEntry) Track(before, after any) error {
+	beforeFields, err := jsonFields(before)
+	if err != nil {
+		return err
+	}
+	e.before = beforeFields
+	e.after = after
+	return nil
+}
+
+// diff records the top level fields of the JSON representations of before and after that differ.
+func (e *AuditEntry) diff() error {
+	beforeFields := e.before
+	afterFields, err := jsonFields(e.after)
+	if err != nil {
+		return err
+	}
+
+	e.Changes = make(map[string]AuditChange)
+	for field, value := range beforeFields {
+		if !bytes.Equal(value, afterFields[field]) {
+			e.Changes[field] = AuditChange{Before: value, After: afterFields[field]}
+		}
+	}
+	for field, value := range afterFields {
+		if _, ok := beforeFields[field]; !ok {
+			e.Changes[field] = AuditChange{After: value}
+		}
+	}
+	return nil
+}
+
+func jsonFields(value any) (map[string]json.RawMessage, error) {
+	fields := make(map[string]json.RawMessage)
+	if value == nil {
+		return fields, nil
+	}
+
+	js, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(js, &fields)
+	if err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// inTransaction runs fn in a transaction and appends the audit entry in the same transaction,
+// so that a change is never stored without its trace. A nil entry is not logged.
+func inTransaction(ctx context.Context, db *sql.DB, entry *AuditEntry, fn func(*sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = fn(tx)
+	if err != nil {
+		return err
+	}
+
+	if entry != nil {
+		err = insertAuditEntry(ctx, tx, entry)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func insertAuditEntry(ctx context.Context, tx *sql.Tx, entry *AuditEntry) error {
+	query := `
+		insert into audit_log (actor_id, ip, action, entity, entity_id, changes, request_id)
+		values ($1, $2, $3, $4, $5, $6, $7)
+		returning id, created_at
+	`
+
+	err := entry.diff()
+	if err != nil {
+		return err
+	}
+	changes, err := json.Marshal(entry.Changes)
+	if err != nil {
+		return err
+	}
+	args := []any{nullInt64(entry.ActorID), entry.IP, entry.Action, entry.Entity, entry.EntityID, changes, entry.RequestID}
+
+	return tx.QueryRowContext(ctx, query, args...).Scan(&entry.ID, &entry.CreatedAt)
+}
+
+type AuditFilter struct {
+	ActorID  int64
+	Entity   string
+	EntityID int64
+	From     time.Time
+	To       time.Time
+}
+
+type AuditModel struct {
+	DB *sql.DB
+}
+
+// GetAll returns the entries matching the filter, newest first. Zero filter fields match everything.
+func (m AuditModel) GetAll(ctx context.Context, filter AuditFilter, filters Filters) ([]*AuditEntry, Metadata, error) {
+	query := `
+		select count(*) over(), id, created_at, coalesce(actor_id, 0), ip, action, entity, entity_id, changes, request_id
+		from audit_log
+		where
+			($1 = 0 or actor_id = $1)
+			and ($2 = '' or entity = $2)
+			and ($3 = 0 or entity_id = $3)
+			and ($4::timestamptz is null or created_at >= $4)
+			and ($5::timestamptz is null or created_at < $5)
+		order by created_at desc, id desc
+		limit $6 offset $7
+	`
+
+	args := []any{filter.ActorID, filter.Entity, filter.EntityID, nullTime(filter.From), nullTime(filter.To), filters.limit(), filters.offset()}
+
+	ctx, cancel := queryContext(ctx, "AuditModel.GetAll", 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	entries := []*AuditEntry{}
+
+	for rows.Next() {
+		var entry AuditEntry
+		var changes []byte
+
+		err := rows.Scan(
+			&totalRecords,
+			&entry.ID,
+			&entry.CreatedAt,
+			&entry.ActorID,
+			&entry.IP,
+			&entry.Action,
+			&entry.Entity,
+			&entry.EntityID,
+			&changes,
+			&entry.RequestID,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		err = json.Unmarshal(changes, &entry.Changes)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		entries = append(entries, &entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return entries, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
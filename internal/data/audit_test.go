@@ -0,0 +1,108 @@
+package data
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestAuditEntryDiff(t *testing.T) {
+	type bike struct {
+		Title string   `json:"title"`
+		Price int      `json:"price"`
+		Tags  []string `json:"tags,omitempty"`
+	}
+
+	tests := []struct {
+		name   string
+		before any
+		after  any
+		want   map[string]AuditChange
+	}{
+		{
+			name:   "created",
+			before: nil,
+			after:  bike{Title: "Trek", Price: 100},
+			want: map[string]AuditChange{
+				"title": {After: json.RawMessage(`"Trek"`)},
+				"price": {After: json.RawMessage(`100`)},
+			},
+		},
+		{
+			name:   "deleted",
+			before: bike{Title: "Trek", Price: 100},
+			after:  nil,
+			want: map[string]AuditChange{
+				"title": {Before: json.RawMessage(`"Trek"`)},
+				"price": {Before: json.RawMessage(`100`)},
+			},
+		},
+		{
+			name:   "changed",
+			before: bike{Title: "Trek", Price: 100},
+			after:  bike{Title: "Trek", Price: 90, Tags: []string{"road"}},
+			want: map[string]AuditChange{
+				"price": {Before: json.RawMessage(`100`), After: json.RawMessage(`90`)},
+				"tags":  {After: json.RawMessage(`["road"]`)},
+			},
+		},
+		{
+			name:   "unchanged",
+			before: bike{Title: "Trek", Price: 100},
+			after:  bike{Title: "Trek", Price: 100},
+			want:   map[string]AuditChange{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var entry AuditEntry
+			if err := entry.Track(tt.before, tt.after); err != nil {
+				t.Fatal(err)
+			}
+			if err := entry.diff(); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(entry.Changes, tt.want) {
+				t.Errorf("Changes = %s, want %s", changesJSON(t, entry.Changes), changesJSON(t, tt.want))
+			}
+		})
+	}
+}
+
+// The state before is captured by Track and the state after only by diff, the model changes
+// the entity in between. Password hashes never end up in the log.
+func TestAuditEntryTrack(t *testing.T) {
+	user := &User{ID: 1, Name: "Alice", Email: "alice@example.com"}
+	if err := user.Password.Set(t.Context(), "pa55word1234"); err != nil {
+		t.Fatal(err)
+	}
+
+	var entry AuditEntry
+	if err := entry.Track(user, user); err != nil {
+		t.Fatal(err)
+	}
+	user.Email = "alice@example.org"
+	if err := user.Password.Set(t.Context(), "n3w-pa55word"); err != nil {
+		t.Fatal(err)
+	}
+	if err := entry.diff(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]AuditChange{
+		"email": {Before: json.RawMessage(`"alice@example.com"`), After: json.RawMessage(`"alice@example.org"`)},
+	}
+	if !reflect.DeepEqual(entry.Changes, want) {
+		t.Errorf("Changes = %s, want %s", changesJSON(t, entry.Changes), changesJSON(t, want))
+	}
+}
+
+func changesJSON(t *testing.T, changes map[string]AuditChange) string {
+	t.Helper()
+	js, err := json.Marshal(changes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(js)
+}
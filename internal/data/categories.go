@@ -0,0 +1,140 @@
+package data
+
+import (
+	"antipinegor/cyclingmarket/internal/validator"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+type Category struct {
+	ID       int64             `json:"-"`
+	ParentID int64             `json:"-"`
+	Slug     string            `json:"slug"`
+	Names    map[string]string `json:"names"`
+	Children []*Category       `json:"children,omitempty"`
+}
+
+type CategoryTree struct {
+	Roots  []*Category
+	bySlug map[string]*Category
+}
+
+func (t *CategoryTree) Contains(slug string) bool {
+	_, ok := t.bySlug[slug]
+	return ok
+}
+
+// Resolve maps values typed by users onto category slugs. A value is matched against slugs
+// and, case-insensitively, against localized names; values that match nothing or more than
+// one category are returned unchanged so that validation can reject them.
+func (t *CategoryTree) Resolve(values []string) []string {
+	if values == nil {
+		return nil
+	}
+
+	resolved := make([]string, 0, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		lower := strings.ToLower(value)
+
+		if t.Contains(lower) {
+			resolved = append(resolved, lower)
+			continue
+		}
+
+		match := ""
+		ambiguous := false
+		for slug, category := range t.bySlug {
+			for _, name := range category.Names {
+				if strings.ToLower(name) == lower {
+					if match != "" && match != slug {
+						ambiguous = true
+					}
+					match = slug
+				}
+			}
+		}
+
+		if match == "" || ambiguous {
+			resolved = append(resolved, value)
+			continue
+		}
+		resolved = append(resolved, match)
+	}
+
+	return resolved
+}
+
+func ValidateCategories(v *validator.Validator, key string, categories []string, tree *CategoryTree) {
+	for _, category := range categories {
+		if !tree.Contains(category) {
+			v.AddError(key, validator.NewMessage("unknown_category"))
+			return
+		}
+	}
+}
+
+type CategoryModel struct {
+	DB *sql.DB
+}
+
+func (m CategoryModel) GetTree(ctx context.Context) (*CategoryTree, error) {
+	query := `
+		select
+			id, coalesce(parent_id, 0), slug, names
+		from
+			categories
+		order by
+			id
+	`
+
+	ctx, cancel := queryContext(ctx, "CategoryModel.GetTree", 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var categories []*Category
+	for rows.Next() {
+		var category Category
+		var names []byte
+		err := rows.Scan(&category.ID, &category.ParentID, &category.Slug, &names)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal(names, &category.Names)
+		if err != nil {
+			return nil, err
+		}
+		categories = append(categories, &category)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	tree := &CategoryTree{
+		Roots:  []*Category{},
+		bySlug: make(map[string]*Category, len(categories)),
+	}
+	byID := make(map[int64]*Category, len(categories))
+	for _, category := range categories {
+		byID[category.ID] = category
+		tree.bySlug[category.Slug] = category
+	}
+	for _, category := range categories {
+		parent, ok := byID[category.ParentID]
+		if !ok {
+			tree.Roots = append(tree.Roots, category)
+			continue
+		}
+		parent.Children = append(parent.Children, category)
+	}
+
+	return tree, nil
+}
@@ -0,0 +1,128 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+const (
+	AdLifetime = 60 * 24 * time.Hour
+	// AdRestoreWindow is how long the owner of a deleted ad can restore it.
+	AdRestoreWindow = 7 * 24 * time.Hour
+	// RenewalReminderWindow is how long before expiry the owner of an ad is reminded to renew it.
+	RenewalReminderWindow = 3 * 24 * time.Hour
+
+	renewalRemindersBatch = 100
+)
+
+type RenewalReminder struct {
+	AdID      int64
+	Title     string
+	ExpiresAt time.Time
+	Email     string
+	Name      string
+	Locale    string
+}
+
+// Renew extends the ad for another AdLifetime from now, an expired ad is listed again.
+func (ad AdModel) Renew(ctx context.Context, adToRenew *Ad, audit *AuditEntry) error {
+	query := `
+		update
+			ads
+		set
+			expires_at = now() + $1 * interval '1 second', renewal_reminder_sent_at = null, updated_at = now(), version = version + 1
+		where
+			id = $2 and deleted_at is null
+		returning expires_at, updated_at, version
+	`
+
+	ctx, cancel := queryContext(ctx, "AdModel.Renew", 3*time.Second)
+	defer cancel()
+
+	return inTransaction(ctx, ad.DB, audit, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, query, int64(AdLifetime.Seconds()), adToRenew.ID).Scan(&adToRenew.ExpiresAt, &adToRenew.UpdatedAt, &adToRenew.Version)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrRecordNotFound
+			default:
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// RemindExpiring queues the message built by remind for ads that expire within
+// RenewalReminderWindow and whose owner has not been reminded yet, and marks them as reminded
+// in the same transaction. A transaction scoped advisory lock makes sure that with several API
+// instances only one of them queues reminders, the others return right away.
+func (ad AdModel) RemindExpiring(ctx context.Context, remind func(*RenewalReminder) *OutboxMessage) (int, error) {
+	query := `
+		select ads.id, ads.title, ads.expires_at, users.email, users.name, users.locale
+		from ads
+		inner join users on users.id = ads.user_id
+		where ads.expires_at > now()
+		and ads.expires_at <= now() + $1 * interval '1 second'
+		and ads.renewal_reminder_sent_at is null
+		and ads.deleted_at is null
+		order by ads.expires_at
+		limit $2
+	`
+
+	ctx, cancel := queryContext(ctx, "AdModel.RemindExpiring", 5*time.Minute)
+	defer cancel()
+
+	tx, err := ad.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var locked bool
+	err = tx.QueryRowContext(ctx, `select pg_try_advisory_xact_lock(hashtext('ad renewal reminders'))`).Scan(&locked)
+	if err != nil || !locked {
+		return 0, err
+	}
+
+	rows, err := tx.QueryContext(ctx, query, int64(RenewalReminderWindow.Seconds()), renewalRemindersBatch)
+	if err != nil {
+		return 0, err
+	}
+
+	reminders := []*RenewalReminder{}
+	for rows.Next() {
+		var reminder RenewalReminder
+		err := rows.Scan(&reminder.AdID, &reminder.Title, &reminder.ExpiresAt, &reminder.Email, &reminder.Name, &reminder.Locale)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		reminders = append(reminders, &reminder)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, reminder := range reminders {
+		err = insertOutboxMessage(ctx, tx, remind(reminder))
+		if err != nil {
+			return 0, err
+		}
+
+		_, err = tx.ExecContext(ctx, `update ads set renewal_reminder_sent_at = now() where id = $1`, reminder.AdID)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return len(reminders), nil
+}
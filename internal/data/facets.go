@@ -0,0 +1,150 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	FacetCategories = "categories"
+	FacetPrice      = "price"
+	FacetCondition  = "condition"
+)
+
+var FacetSafelist = []string{FacetCategories, FacetPrice, FacetCondition}
+
+// priceBucketBounds splits prices into [0, 100), [100, 500), ..., [5000, +inf).
+var priceBucketBounds = []Price{100, 500, 1000, 2000, 5000}
+
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+type PriceBucket struct {
+	From  Price  `json:"from"`
+	To    *Price `json:"to,omitempty"`
+	Count int    `json:"count"`
+}
+
+type Facets struct {
+	Categories []FacetCount  `json:"categories,omitempty"`
+	Price      []PriceBucket `json:"price,omitempty"`
+	Condition  []FacetCount  `json:"condition,omitempty"`
+}
+
+// getFacets counts ads matching the same filter as GetAll, grouped by every requested facet.
+// Category counts include ads listed in descendant categories, so "bikes" counts every bike.
+func (ad AdModel) getFacets(ctx context.Context, q querier, title string, categories []string, facets []string) (Facets, error) {
+	subqueries := make([]string, 0, len(facets))
+	for _, facet := range facets {
+		switch facet {
+		case FacetCategories:
+			subqueries = append(subqueries, `
+			select 'categories', ancestry.ancestor, count(distinct filtered.id)
+			from filtered
+			cross join unnest(filtered.categories) as category(slug)
+			inner join ancestry on ancestry.slug = category.slug
+			group by ancestry.ancestor`)
+		case FacetPrice:
+			subqueries = append(subqueries, `
+			select 'price', width_bucket(filtered.price, $3::integer[])::text, count(*)
+			from filtered
+			group by 2`)
+		case FacetCondition:
+			subqueries = append(subqueries, `
+			select 'condition', filtered.condition, count(*)
+			from filtered
+			group by filtered.condition`)
+		default:
+			panic("unsafe facet parameter: " + facet)
+		}
+	}
+
+	result := Facets{}
+	if len(subqueries) == 0 {
+		return result, nil
+	}
+
+	query := fmt.Sprintf(`
+		with recursive %s,
+		ancestry(slug, parent_id, ancestor) as (
+			select slug, parent_id, slug from categories
+			union all
+			select ancestry.slug, categories.parent_id, categories.slug
+			from categories
+			inner join ancestry on categories.id = ancestry.parent_id
+		),
+		filtered as (
+			select id, price, categories, condition
+			from ads
+			where %s
+		)
+		%s
+		order by 1, 3 desc, 2
+	`, adsRequestedCategories, adsFilter, strings.Join(subqueries, "\n\t\t\tunion all"))
+
+	args := []any{title, pq.Array(categories)}
+	if slices.Contains(facets, FacetPrice) {
+		bounds := make([]int32, len(priceBucketBounds))
+		for i, bound := range priceBucketBounds {
+			bounds[i] = int32(bound)
+		}
+		args = append(args, pq.Array(bounds))
+	}
+
+	ctx, cancel := queryContext(ctx, "AdModel.GetFacets", 3*time.Second)
+	defer cancel()
+
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return Facets{}, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var facet, value string
+		var count int
+		err := rows.Scan(&facet, &value, &count)
+		if err != nil {
+			return Facets{}, err
+		}
+
+		switch facet {
+		case FacetCategories:
+			result.Categories = append(result.Categories, FacetCount{Value: value, Count: count})
+		case FacetCondition:
+			result.Condition = append(result.Condition, FacetCount{Value: value, Count: count})
+		case FacetPrice:
+			result.Price = append(result.Price, priceBucket(value, count))
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return Facets{}, err
+	}
+
+	slices.SortFunc(result.Price, func(a, b PriceBucket) int {
+		return int(a.From - b.From)
+	})
+
+	return result, nil
+}
+
+func priceBucket(index string, count int) PriceBucket {
+	i, _ := strconv.Atoi(index)
+
+	bucket := PriceBucket{Count: count}
+	if i > 0 {
+		bucket.From = priceBucketBounds[i-1]
+	}
+	if i < len(priceBucketBounds) {
+		bucket.To = &priceBucketBounds[i]
+	}
+	return bucket
+}
@@ -0,0 +1,209 @@
+package data
+
+import (
+	"antipinegor/cyclingmarket/internal/validator"
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const DefaultPriceDropThreshold = 10
+
+// Favorite is an ad a user watches. The user is notified once the price drops by at least
+// PriceDropThreshold percent below the price they were last notified about, or the price
+// the ad had when it was added to favorites.
+type Favorite struct {
+	AdID               int64     `json:"ad_id"`
+	Title              string    `json:"title"`
+	Price              Price     `json:"price"`
+	PriceDropThreshold int       `json:"price_drop_threshold"`
+	CreatedAt          time.Time `json:"created_at"`
+}
+
+type PriceDropWatcher struct {
+	UserID         int64
+	Email          string
+	Name           string
+	Locale         string
+	ReferencePrice Price
+}
+
+// DropPercent is by how many whole percent price is below the reference price of the watcher.
+// It is rounded down like the threshold comparison of priceDropWatchers, so it never shows
+// less than the threshold that was reached.
+func (w *PriceDropWatcher) DropPercent(price Price) int64 {
+	if w.ReferencePrice <= 0 || price >= w.ReferencePrice {
+		return 0
+	}
+	return 100 * (int64(w.ReferencePrice) - int64(price)) / int64(w.ReferencePrice)
+}
+
+func ValidatePriceDropThreshold(v *validator.Validator, threshold int) {
+	v.Check(threshold >= 1, "price_drop_threshold", validator.NewMessage("min_percent", 1))
+	v.Check(threshold <= 100, "price_drop_threshold", validator.NewMessage("max_percent", 100))
+}
+
+type FavoriteModel struct {
+	DB *sql.DB
+}
+
+// Upsert adds the ad to the favorites of the user or changes the threshold of an existing favorite.
+func (m FavoriteModel) Upsert(ctx context.Context, userID, adID int64, threshold int) (*Favorite, error) {
+	query := `
+		with upserted as (
+			insert into favorites (user_id, ad_id, price_drop_threshold, reference_price)
+			select $1, ads.id, $3, ads.price
+			from ads
+			where ads.id = $2 and ads.deleted_at is null
+			on conflict (user_id, ad_id) do update
+			set price_drop_threshold = excluded.price_drop_threshold
+			returning ad_id, price_drop_threshold, created_at
+		)
+		select upserted.ad_id, ads.title, ads.price, upserted.price_drop_threshold, upserted.created_at
+		from upserted
+		inner join ads on ads.id = upserted.ad_id
+	`
+
+	var favorite Favorite
+
+	ctx, cancel := queryContext(ctx, "FavoriteModel.Upsert", 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID, adID, threshold).Scan(
+		&favorite.AdID,
+		&favorite.Title,
+		&favorite.Price,
+		&favorite.PriceDropThreshold,
+		&favorite.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &favorite, nil
+}
+
+func (m FavoriteModel) GetAllForUser(ctx context.Context, userID int64) ([]*Favorite, error) {
+	query := `
+		select ads.id, ads.title, ads.price, favorites.price_drop_threshold, favorites.created_at
+		from favorites
+		inner join ads on ads.id = favorites.ad_id
+		where favorites.user_id = $1 and ads.deleted_at is null
+		order by favorites.created_at desc, ads.id
+	`
+
+	ctx, cancel := queryContext(ctx, "FavoriteModel.GetAllForUser", 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	favorites := []*Favorite{}
+	for rows.Next() {
+		var favorite Favorite
+		err := rows.Scan(&favorite.AdID, &favorite.Title, &favorite.Price, &favorite.PriceDropThreshold, &favorite.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		favorites = append(favorites, &favorite)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return favorites, nil
+}
+
+func (m FavoriteModel) Delete(ctx context.Context, userID, adID int64) error {
+	query := `
+		delete from favorites
+		where user_id = $1 and ad_id = $2
+	`
+
+	ctx, cancel := queryContext(ctx, "FavoriteModel.Delete", 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, adID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// priceDropWatchers returns the users whose threshold is reached by the new price of the ad.
+func priceDropWatchers(ctx context.Context, tx *sql.Tx, adID int64, price Price) ([]*PriceDropWatcher, error) {
+	query := `
+		select users.id, users.email, users.name, users.locale, favorites.reference_price
+		from favorites
+		inner join users on users.id = favorites.user_id
+		where favorites.ad_id = $1
+		and favorites.reference_price > $2
+		and 100 * (favorites.reference_price - $2)::bigint >= favorites.price_drop_threshold * favorites.reference_price::bigint
+	`
+
+	rows, err := tx.QueryContext(ctx, query, adID, price)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	watchers := []*PriceDropWatcher{}
+	for rows.Next() {
+		var watcher PriceDropWatcher
+		err := rows.Scan(&watcher.UserID, &watcher.Email, &watcher.Name, &watcher.Locale, &watcher.ReferencePrice)
+		if err != nil {
+			return nil, err
+		}
+		watchers = append(watchers, &watcher)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return watchers, nil
+}
+
+// notifyPriceDrop queues the message built by notify for every user whose threshold the new
+// price of the ad reaches and records the price they are notified about, the next drop is
+// measured from it.
+func notifyPriceDrop(ctx context.Context, tx *sql.Tx, adID int64, price Price, notify func(*PriceDropWatcher) *OutboxMessage) error {
+	watchers, err := priceDropWatchers(ctx, tx, adID, price)
+	if err != nil {
+		return err
+	}
+
+	userIDs := make([]int64, 0, len(watchers))
+	for _, watcher := range watchers {
+		err = insertOutboxMessage(ctx, tx, notify(watcher))
+		if err != nil {
+			return err
+		}
+		userIDs = append(userIDs, watcher.UserID)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		update favorites
+		set reference_price = $2
+		where ad_id = $1 and user_id = any($3)
+	`, adID, price, pq.Array(userIDs))
+	return err
+}
@@ -0,0 +1,41 @@
+package data
+
+import "testing"
+
+// The cases mirror the condition of priceDropWatchers: a watcher is notified when
+// 100 * (reference - price) >= threshold * reference.
+func TestPriceDropWatcherDropPercent(t *testing.T) {
+	tests := []struct {
+		name      string
+		reference Price
+		price     Price
+		threshold int
+		percent   int64
+		reached   bool
+	}{
+		{"exactly the threshold", 1000, 900, 10, 10, true},
+		{"just short of the threshold", 1000, 901, 10, 9, false},
+		{"rounded down to the threshold", 999, 899, 10, 10, true},
+		{"rounded down below the threshold", 3, 2, 34, 33, false},
+		{"one percent", 100, 99, 1, 1, true},
+		{"free", 1000, 0, 100, 100, true},
+		{"unchanged", 1000, 1000, 1, 0, false},
+		{"raised", 1000, 1200, 1, 0, false},
+		{"no reference", 0, 0, 1, 0, false},
+		{"largest prices", 2_147_483_647, 1, 100, 99, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			watcher := &PriceDropWatcher{ReferencePrice: tt.reference}
+
+			percent := watcher.DropPercent(tt.price)
+			if percent != tt.percent {
+				t.Errorf("DropPercent(%d) from %d = %d, want %d", tt.price, tt.reference, percent, tt.percent)
+			}
+			if reached := percent >= int64(tt.threshold); reached != tt.reached {
+				t.Errorf("DropPercent(%d) from %d reaches threshold %d = %t, want %t", tt.price, tt.reference, tt.threshold, reached, tt.reached)
+			}
+		})
+	}
+}
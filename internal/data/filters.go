@@ -2,15 +2,22 @@ package data
 
 import (
 	"antipinegor/cyclingmarket/internal/validator"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math"
+	"strconv"
 	"strings"
+	"unicode/utf8"
 )
 
 type Metadata struct {
-	CurrentPage  int `json:"current_page,omitzero"`
-	PageSize     int `json:"page_size,omitzero"`
-	FirstPage    int `json:"first_page,omitzero"`
-	LastPage     int `json:"last_page,omitzero"`
-	TotalRecords int `json:"total_records,omitzero"`
+	CurrentPage  int    `json:"current_page,omitzero"`
+	PageSize     int    `json:"page_size,omitzero"`
+	FirstPage    int    `json:"first_page,omitzero"`
+	LastPage     int    `json:"last_page,omitzero"`
+	TotalRecords int    `json:"total_records,omitzero"`
+	NextCursor   string `json:"next_cursor,omitempty"`
 }
 
 func calculateMetadata(totalRecords, page, pageSize int) Metadata {
@@ -26,19 +33,80 @@ func calculateMetadata(totalRecords, page, pageSize int) Metadata {
 	}
 }
 
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor points right after the last row of a page: the value of the sort column and the id
+// tie-breaker of that row. It is handed to clients as an opaque base64 token.
+type Cursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    int64  `json:"id"`
+}
+
+func (c Cursor) Encode() string {
+	js, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(js)
+}
+
+func DecodeCursor(token string) (*Cursor, error) {
+	js, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var cursor Cursor
+	err = json.Unmarshal(js, &cursor)
+	if err != nil || cursor.ID < 1 {
+		return nil, ErrInvalidCursor
+	}
+
+	return &cursor, nil
+}
+
 type Filters struct {
 	Page         int
 	PageSize     int
 	Sort         string
 	SortSafelist []string
+	Cursor       *Cursor
+	IncludeTotal bool
 }
 
 func ValidateFilters(v *validator.Validator, f Filters) {
-	v.Check(f.Page > 0, "page", "must be greater than zero")
-	v.Check(f.Page <= 10_000_000, "page", "must be a maximum of 10 million")
-	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
-	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
-	v.Check(validator.PermittedValue(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
+	v.Check(f.Page > 0, "page", validator.Positive)
+	v.Check(f.Page <= 10_000_000, "page", validator.MaxValue(10_000_000))
+	v.Check(f.PageSize > 0, "page_size", validator.Positive)
+	v.Check(f.PageSize <= 100, "page_size", validator.MaxValue(100))
+	v.Check(validator.PermittedValue(f.Sort, f.SortSafelist...), "sort", validator.InvalidValue)
+
+	if f.Cursor != nil {
+		v.Check(f.Page == 1, "page", validator.NewMessage("page_with_cursor"))
+		v.Check(f.Cursor.Sort == f.Sort, "cursor", validator.NewMessage("cursor_sort_mismatch"))
+
+		// cursors are only issued by the ads listing, the value is compared with its sort key
+		if v.Valid() {
+			_, sqlType := adsSortKey(f.sortColumn())
+			v.Check(validCursorValue(f.Cursor.Value, sqlType), "cursor", validator.NewMessage("invalid_cursor"))
+		}
+	}
+}
+
+// validCursorValue reports whether PostgreSQL can cast the cursor value to the SQL type of the
+// sort key, clients can tamper with cursors.
+func validCursorValue(value, sqlType string) bool {
+	switch sqlType {
+	case "integer":
+		_, err := strconv.ParseInt(value, 10, 32)
+		return err == nil
+	case "bigint":
+		_, err := strconv.ParseInt(value, 10, 64)
+		return err == nil
+	case "real":
+		f, err := strconv.ParseFloat(value, 32)
+		return err == nil && !math.IsNaN(f) && !math.IsInf(f, 0)
+	default:
+		return utf8.ValidString(value) && !strings.ContainsRune(value, 0)
+	}
 }
 
 func (f Filters) sortColumn() string {
@@ -51,16 +119,29 @@ func (f Filters) sortColumn() string {
 }
 
 func (f Filters) sortDirection() string {
-	if strings.HasPrefix(f.Sort, "-") {
+	// relevance only makes sense from the best match down
+	if strings.HasPrefix(f.Sort, "-") || f.Sort == "relevance" {
 		return "DESC"
 	}
 	return "ASC"
 }
 
+// keysetOperator compares the sort column with the cursor value, rows with equal values
+// are ordered by id ascending in both directions.
+func (f Filters) keysetOperator() string {
+	if f.sortDirection() == "DESC" {
+		return "<"
+	}
+	return ">"
+}
+
 func (f Filters) limit() int {
 	return f.PageSize
 }
 
 func (f Filters) offset() int {
+	if f.Cursor != nil {
+		return 0
+	}
 	return (f.Page - 1) * f.PageSize
 }
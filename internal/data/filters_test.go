@@ -0,0 +1,91 @@
+package data
+
+import (
+	"antipinegor/cyclingmarket/internal/validator"
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+func TestDecodeCursor(t *testing.T) {
+	cursor := Cursor{Sort: "-price", Value: "1500", ID: 42}
+
+	decoded, err := DecodeCursor(cursor.Encode())
+	if err != nil {
+		t.Fatalf("DecodeCursor(Encode()) returned %v", err)
+	}
+	if *decoded != cursor {
+		t.Errorf("DecodeCursor(Encode()) = %+v, want %+v", *decoded, cursor)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"empty", ""},
+		{"not base64", "!!!"},
+		{"padded base64", base64.URLEncoding.EncodeToString([]byte(`{"s":"id","v":"1","id":1}`))},
+		{"not json", base64.RawURLEncoding.EncodeToString([]byte("id=1"))},
+		{"wrong type", base64.RawURLEncoding.EncodeToString([]byte(`{"s":"id","v":"1","id":"1"}`))},
+		{"missing id", base64.RawURLEncoding.EncodeToString([]byte(`{"s":"id","v":"1"}`))},
+		{"negative id", base64.RawURLEncoding.EncodeToString([]byte(`{"s":"id","v":"1","id":-1}`))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := DecodeCursor(tt.token)
+			if !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("DecodeCursor(%q) returned %v, want ErrInvalidCursor", tt.token, err)
+			}
+		})
+	}
+}
+
+func TestValidateFilters(t *testing.T) {
+	safelist := []string{"id", "title", "price", "relevance", "-id", "-title", "-price"}
+
+	tests := []struct {
+		name    string
+		filters Filters
+		field   string
+		code    string
+	}{
+		{"first page", Filters{Page: 1, PageSize: 20, Sort: "id"}, "", ""},
+		{"zero page", Filters{Page: 0, PageSize: 20, Sort: "id"}, "page", "positive"},
+		{"page too large", Filters{Page: 10_000_001, PageSize: 20, Sort: "id"}, "page", "max_value"},
+		{"zero page size", Filters{Page: 1, PageSize: 0, Sort: "id"}, "page_size", "positive"},
+		{"page size too large", Filters{Page: 1, PageSize: 101, Sort: "id"}, "page_size", "max_value"},
+		{"unsafe sort", Filters{Page: 1, PageSize: 20, Sort: "id; drop table ads"}, "sort", "invalid_value"},
+		{"cursor", Filters{Page: 1, PageSize: 20, Sort: "-price", Cursor: &Cursor{Sort: "-price", Value: "1500", ID: 7}}, "", ""},
+		{"cursor with page", Filters{Page: 2, PageSize: 20, Sort: "id", Cursor: &Cursor{Sort: "id", Value: "7", ID: 7}}, "page", "page_with_cursor"},
+		{"cursor of another sort", Filters{Page: 1, PageSize: 20, Sort: "price", Cursor: &Cursor{Sort: "id", Value: "7", ID: 7}}, "cursor", "cursor_sort_mismatch"},
+		{"price not a number", Filters{Page: 1, PageSize: 20, Sort: "price", Cursor: &Cursor{Sort: "price", Value: "abc", ID: 7}}, "cursor", "invalid_cursor"},
+		{"price out of integer", Filters{Page: 1, PageSize: 20, Sort: "price", Cursor: &Cursor{Sort: "price", Value: "9999999999", ID: 7}}, "cursor", "invalid_cursor"},
+		{"id out of bigint", Filters{Page: 1, PageSize: 20, Sort: "-id", Cursor: &Cursor{Sort: "-id", Value: "99999999999999999999", ID: 7}}, "cursor", "invalid_cursor"},
+		{"relevance", Filters{Page: 1, PageSize: 20, Sort: "relevance", Cursor: &Cursor{Sort: "relevance", Value: "0.0607927", ID: 7}}, "", ""},
+		{"relevance NaN", Filters{Page: 1, PageSize: 20, Sort: "relevance", Cursor: &Cursor{Sort: "relevance", Value: "NaN", ID: 7}}, "cursor", "invalid_cursor"},
+		{"relevance infinite", Filters{Page: 1, PageSize: 20, Sort: "relevance", Cursor: &Cursor{Sort: "relevance", Value: "Inf", ID: 7}}, "cursor", "invalid_cursor"},
+		{"title", Filters{Page: 1, PageSize: 20, Sort: "title", Cursor: &Cursor{Sort: "title", Value: "Trek Émonda", ID: 7}}, "", ""},
+		{"title with NUL", Filters{Page: 1, PageSize: 20, Sort: "title", Cursor: &Cursor{Sort: "title", Value: "Trek\x00", ID: 7}}, "cursor", "invalid_cursor"},
+		{"title not UTF-8", Filters{Page: 1, PageSize: 20, Sort: "-title", Cursor: &Cursor{Sort: "-title", Value: "\xff", ID: 7}}, "cursor", "invalid_cursor"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.filters.SortSafelist = safelist
+
+			v := validator.New()
+			ValidateFilters(v, tt.filters)
+
+			if tt.field == "" {
+				if !v.Valid() {
+					t.Errorf("ValidateFilters(%+v) returned errors %v, want none", tt.filters, v.Errors)
+				}
+				return
+			}
+			if message, ok := v.Errors[tt.field]; !ok || message.Code != tt.code {
+				t.Errorf("ValidateFilters(%+v) returned errors %v, want %s on %s", tt.filters, v.Errors, tt.code, tt.field)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package data
+
+import (
+	"antipinegor/cyclingmarket/internal/validator"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+const IdempotencyKeyTTL = 24 * time.Hour
+
+var (
+	ErrDuplicateIdempotencyKey = errors.New("duplicate idempotency key")
+)
+
+// IdempotentRequest is a POST request made with an Idempotency-Key header. Until the response
+// is stored Status is zero, which means the first request is still being processed. Keys are
+// scoped by user, anonymous requests share the user ID zero and are scoped by ClientIP instead.
+type IdempotentRequest struct {
+	UserID      int64
+	ClientIP    string
+	Key         string
+	Fingerprint []byte
+	Status      int
+	Headers     http.Header
+	Body        []byte
+	CreatedAt   time.Time
+}
+
+func ValidateIdempotencyKey(v *validator.Validator, key string) {
+	v.Check(len(key) >= 8, "Idempotency-Key", validator.MinBytes(8))
+	v.Check(len(key) <= 255, "Idempotency-Key", validator.MaxBytes(255))
+}
+
+type IdempotencyModel struct {
+	DB *sql.DB
+}
+
+// Insert claims the key for a new request. A key that expired is claimed again,
+// a live one fails with ErrDuplicateIdempotencyKey.
+func (m IdempotencyModel) Insert(ctx context.Context, request *IdempotentRequest) error {
+	query := `
+		insert into idempotency_keys (user_id, client_ip, key, fingerprint)
+		values ($1, $2, $3, $4)
+		on conflict (user_id, client_ip, key) do update
+		set fingerprint = excluded.fingerprint, status = null, headers = '{}', body = null, created_at = now()
+		where idempotency_keys.created_at < now() - $5 * interval '1 second'
+		returning created_at
+	`
+	args := []any{request.UserID, request.ClientIP, request.Key, request.Fingerprint, int64(IdempotencyKeyTTL.Seconds())}
+
+	ctx, cancel := queryContext(ctx, "IdempotencyModel.Insert", 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&request.CreatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrDuplicateIdempotencyKey
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m IdempotencyModel) Get(ctx context.Context, userID int64, clientIP, key string) (*IdempotentRequest, error) {
+	query := `
+		select user_id, client_ip, key, fingerprint, coalesce(status, 0), headers, body, created_at
+		from idempotency_keys
+		where user_id = $1 and client_ip = $2 and key = $3 and created_at >= now() - $4 * interval '1 second'
+	`
+
+	var request IdempotentRequest
+	var headers []byte
+
+	ctx, cancel := queryContext(ctx, "IdempotencyModel.Get", 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID, clientIP, key, int64(IdempotencyKeyTTL.Seconds())).Scan(
+		&request.UserID,
+		&request.ClientIP,
+		&request.Key,
+		&request.Fingerprint,
+		&request.Status,
+		&headers,
+		&request.Body,
+		&request.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	err = json.Unmarshal(headers, &request.Headers)
+	if err != nil {
+		return nil, err
+	}
+
+	return &request, nil
+}
+
+func (m IdempotencyModel) SaveResponse(ctx context.Context, request *IdempotentRequest) error {
+	query := `
+		update idempotency_keys
+		set status = $1, headers = $2, body = $3
+		where user_id = $4 and client_ip = $5 and key = $6
+	`
+
+	headers, err := json.Marshal(request.Headers)
+	if err != nil {
+		return err
+	}
+	args := []any{request.Status, headers, request.Body, request.UserID, request.ClientIP, request.Key}
+
+	ctx, cancel := queryContext(ctx, "IdempotencyModel.SaveResponse", 3*time.Second)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// Delete releases the key of a request that failed on the server side, so that it can be retried.
+func (m IdempotencyModel) Delete(ctx context.Context, userID int64, clientIP, key string) error {
+	query := `
+		delete from idempotency_keys
+		where user_id = $1 and client_ip = $2 and key = $3
+	`
+
+	ctx, cancel := queryContext(ctx, "IdempotencyModel.Delete", 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, clientIP, key)
+	return err
+}
+
+func (m IdempotencyModel) DeleteExpired(ctx context.Context) error {
+	query := `
+		delete from idempotency_keys
+		where created_at < now() - $1 * interval '1 second'
+	`
+
+	ctx, cancel := queryContext(ctx, "IdempotencyModel.DeleteExpired", 30*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, int64(IdempotencyKeyTTL.Seconds()))
+	return err
+}
@@ -0,0 +1,149 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"antipinegor/cyclingmarket/internal/validator"
+
+	"github.com/lib/pq"
+)
+
+// MaxAdImages is how many images a single ad can have.
+const MaxAdImages = 10
+
+var ErrTooManyImages = errors.New("too many images")
+
+type AdImage struct {
+	ID       int64  `json:"id"`
+	AdID     int64  `json:"-"`
+	URL      string `json:"url"`
+	Position int    `json:"position"`
+}
+
+// ValidateAdImage checks the image URL, images are hosted elsewhere and only linked to the ad.
+func ValidateAdImage(v *validator.Validator, image *AdImage) {
+	v.Check(image.URL != "", "url", validator.Required)
+	v.Check(len(image.URL) <= 500, "url", validator.MaxBytes(500))
+	v.Check(validator.HTTPURL(image.URL), "url", validator.NewMessage("invalid_url"))
+}
+
+type AdImageModel struct {
+	DB *sql.DB
+}
+
+func (m AdImageModel) GetForAds(ctx context.Context, adIDs []int64) (map[int64][]*AdImage, error) {
+	query := `
+		select id, ad_id, url, position
+		from ad_images
+		where ad_id = any($1)
+		order by ad_id, position, id
+	`
+
+	ctx, cancel := queryContext(ctx, "AdImageModel.GetForAds", 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(adIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	images := make(map[int64][]*AdImage)
+	for rows.Next() {
+		var image AdImage
+		err := rows.Scan(&image.ID, &image.AdID, &image.URL, &image.Position)
+		if err != nil {
+			return nil, err
+		}
+		images[image.AdID] = append(images[image.AdID], &image)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return images, nil
+}
+
+// Insert adds an image to an ad that isn't deleted. A negative position appends the image after
+// the existing ones. The ad's version is bumped, since its representation with the images changes.
+func (m AdImageModel) Insert(ctx context.Context, image *AdImage, audit *AuditEntry) error {
+	ctx, cancel := queryContext(ctx, "AdImageModel.Insert", 3*time.Second)
+	defer cancel()
+
+	return inTransaction(ctx, m.DB, audit, func(tx *sql.Tx) error {
+		// locks the ad, so concurrent inserts can't exceed MaxAdImages
+		err := touchAd(ctx, tx, image.AdID)
+		if err != nil {
+			return err
+		}
+
+		var count, next int
+		err = tx.QueryRowContext(ctx, `select count(*), coalesce(max(position) + 1, 0) from ad_images where ad_id = $1`, image.AdID).Scan(&count, &next)
+		if err != nil {
+			return err
+		}
+		if count >= MaxAdImages {
+			return ErrTooManyImages
+		}
+		if image.Position < 0 {
+			image.Position = next
+		}
+
+		query := `
+			insert into ad_images (ad_id, url, position)
+			values ($1, $2, $3)
+			returning id
+		`
+		return tx.QueryRowContext(ctx, query, image.AdID, image.URL, image.Position).Scan(&image.ID)
+	})
+}
+
+// Delete removes an image of an ad that isn't deleted and bumps the ad's version.
+func (m AdImageModel) Delete(ctx context.Context, adID, imageID int64, audit *AuditEntry) error {
+	ctx, cancel := queryContext(ctx, "AdImageModel.Delete", 3*time.Second)
+	defer cancel()
+
+	return inTransaction(ctx, m.DB, audit, func(tx *sql.Tx) error {
+		err := touchAd(ctx, tx, adID)
+		if err != nil {
+			return err
+		}
+
+		result, err := tx.ExecContext(ctx, `delete from ad_images where id = $1 and ad_id = $2`, imageID, adID)
+		if err != nil {
+			return err
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rowsAffected == 0 {
+			return ErrRecordNotFound
+		}
+		return nil
+	})
+}
+
+func touchAd(ctx context.Context, tx *sql.Tx, adID int64) error {
+	query := `
+		update ads
+		set updated_at = now(), version = version + 1
+		where id = $1 and deleted_at is null
+	`
+
+	result, err := tx.ExecContext(ctx, query, adID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
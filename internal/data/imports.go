@@ -0,0 +1,151 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+const (
+	ImportFormatCSV    = "csv"
+	ImportFormatNDJSON = "ndjson"
+
+	ImportStatusPending    = "pending"
+	ImportStatusProcessing = "processing"
+	ImportStatusCompleted  = "completed"
+	ImportStatusFailed     = "failed"
+)
+
+type AdImportError struct {
+	Row    int               `json:"row"`
+	Errors map[string]string `json:"errors"`
+}
+
+type AdImport struct {
+	ID         int64      `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	UserID     int64      `json:"-"`
+	// OrganizationID is the dealer the ads are imported for, zero for a private seller
+	OrganizationID int64           `json:"organization_id,omitempty"`
+	Format         string          `json:"format"`
+	Atomic         bool            `json:"atomic"`
+	Status         string          `json:"status"`
+	TotalRows      int             `json:"total_rows"`
+	ImportedRows   int             `json:"imported_rows"`
+	Errors         []AdImportError `json:"errors"`
+}
+
+type AdImportModel struct {
+	DB *sql.DB
+}
+
+func (m AdImportModel) Insert(ctx context.Context, imp *AdImport) error {
+	query := `
+		insert into ad_imports (user_id, organization_id, format, atomic, status, total_rows)
+		values ($1, $2, $3, $4, $5, $6)
+		returning id, created_at
+	`
+	args := []any{imp.UserID, nullInt64(imp.OrganizationID), imp.Format, imp.Atomic, imp.Status, imp.TotalRows}
+
+	ctx, cancel := queryContext(ctx, "AdImportModel.Insert", 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&imp.ID, &imp.CreatedAt)
+}
+
+func (m AdImportModel) GetForUser(ctx context.Context, id, userID int64) (*AdImport, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		select id, created_at, finished_at, user_id, coalesce(organization_id, 0), format, atomic, status, total_rows, imported_rows, errors
+		from ad_imports
+		where id = $1 and user_id = $2
+	`
+
+	var imp AdImport
+	var importErrors []byte
+
+	ctx, cancel := queryContext(ctx, "AdImportModel.GetForUser", 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id, userID).Scan(
+		&imp.ID,
+		&imp.CreatedAt,
+		&imp.FinishedAt,
+		&imp.UserID,
+		&imp.OrganizationID,
+		&imp.Format,
+		&imp.Atomic,
+		&imp.Status,
+		&imp.TotalRows,
+		&imp.ImportedRows,
+		&importErrors,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	err = json.Unmarshal(importErrors, &imp.Errors)
+	if err != nil {
+		return nil, err
+	}
+
+	return &imp, nil
+}
+
+// Update saves the progress of an import, finished_at is set once it leaves the processing state.
+// Every update counts as a sign of life for FailStale.
+func (m AdImportModel) Update(ctx context.Context, imp *AdImport) error {
+	query := `
+		update ad_imports
+		set
+			status = $1,
+			imported_rows = $2,
+			errors = $3,
+			updated_at = now(),
+			finished_at = case when $1 in ('completed', 'failed') then now() else null end
+		where id = $4
+		returning finished_at
+	`
+
+	importErrors, err := json.Marshal(imp.Errors)
+	if err != nil {
+		return err
+	}
+	args := []any{imp.Status, imp.ImportedRows, importErrors, imp.ID}
+
+	ctx, cancel := queryContext(ctx, "AdImportModel.Update", 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&imp.FinishedAt)
+}
+
+// FailStale marks imports that were not updated for longer than timeout as failed. Imports are
+// processed in the background of the instance they were uploaded to, the ones it was processing
+// when it stopped are never finished otherwise.
+func (m AdImportModel) FailStale(ctx context.Context, timeout time.Duration) (int64, error) {
+	query := `
+		update ad_imports
+		set status = 'failed', updated_at = now(), finished_at = now()
+		where status in ('pending', 'processing') and updated_at < now() - $1 * interval '1 second'
+	`
+
+	ctx, cancel := queryContext(ctx, "AdImportModel.FailStale", 30*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, int64(timeout.Seconds()))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -11,10 +11,20 @@ var (
 )
 
 type Models struct {
-	Ads         AdModel
-	Users       UserModel
-	Permissions PermissionModel
-	Tokens      TokenModel
+	Ads           AdModel
+	Users         UserModel
+	Permissions   PermissionModel
+	Tokens        TokenModel
+	StolenBikes   StolenBikeModel
+	Categories    CategoryModel
+	Suggestions   SuggestionModel
+	AdImages      AdImageModel
+	Idempotency   IdempotencyModel
+	AdImports     AdImportModel
+	Organizations OrganizationModel
+	Favorites     FavoriteModel
+	Audit         AuditModel
+	Outbox        OutboxModel
 }
 
 func NewModels(db *sql.DB) Models {
@@ -30,10 +40,50 @@ func NewModels(db *sql.DB) Models {
 	tokenModel := TokenModel{
 		DB: db,
 	}
+	stolenBikeModel := StolenBikeModel{
+		DB: db,
+	}
+	categoryModel := CategoryModel{
+		DB: db,
+	}
+	suggestionModel := SuggestionModel{
+		DB: db,
+	}
+	adImageModel := AdImageModel{
+		DB: db,
+	}
+	idempotencyModel := IdempotencyModel{
+		DB: db,
+	}
+	adImportModel := AdImportModel{
+		DB: db,
+	}
+	organizationModel := OrganizationModel{
+		DB: db,
+	}
+	favoriteModel := FavoriteModel{
+		DB: db,
+	}
+	auditModel := AuditModel{
+		DB: db,
+	}
+	outboxModel := OutboxModel{
+		DB: db,
+	}
 	return Models{
-		Ads:         adModel,
-		Users:       userModel,
-		Permissions: permModel,
-		Tokens:      tokenModel,
+		Ads:           adModel,
+		Users:         userModel,
+		Permissions:   permModel,
+		Tokens:        tokenModel,
+		StolenBikes:   stolenBikeModel,
+		Categories:    categoryModel,
+		Suggestions:   suggestionModel,
+		AdImages:      adImageModel,
+		Idempotency:   idempotencyModel,
+		AdImports:     adImportModel,
+		Organizations: organizationModel,
+		Favorites:     favoriteModel,
+		Audit:         auditModel,
+		Outbox:        outboxModel,
 	}
 }
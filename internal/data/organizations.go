@@ -0,0 +1,331 @@
+package data
+
+import (
+	"antipinegor/cyclingmarket/internal/validator"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	RoleOwner   = "owner"
+	RoleManager = "manager"
+	RoleStaff   = "staff"
+)
+
+var Roles = []string{RoleOwner, RoleManager, RoleStaff}
+
+// RolePermissions are the permission codes a role grants inside its organization.
+var RolePermissions = map[string]Permissions{
+	RoleOwner:   {"org:read", "org:update", "org:members", "ads:write"},
+	RoleManager: {"org:read", "org:update", "ads:write"},
+	RoleStaff:   {"org:read", "ads:write"},
+}
+
+var (
+	ErrDuplicateSlug   = errors.New("duplicate slug")
+	ErrDuplicateMember = errors.New("duplicate member")
+	ErrLastOwner       = errors.New("last owner")
+
+	SlugRX         = regexp.MustCompile(`^[a-z0-9]+(?:-[a-z0-9]+)*$`)
+	OpeningHoursRX = regexp.MustCompile(`^(closed|([01][0-9]|2[0-3]):[0-5][0-9]-([01][0-9]|2[0-3]):[0-5][0-9])$`)
+	weekdays       = []string{"mon", "tue", "wed", "thu", "fri", "sat", "sun"}
+)
+
+type Organization struct {
+	ID           int64             `json:"id"`
+	CreatedAt    time.Time         `json:"created_at"`
+	Name         string            `json:"name"`
+	Slug         string            `json:"slug"`
+	Description  string            `json:"description"`
+	LogoURL      string            `json:"logo_url"`
+	OpeningHours map[string]string `json:"opening_hours"`
+	Version      int32             `json:"version"`
+}
+
+// OrganizationReadOnlyFields are the fields of an organization a PATCH request must leave as they are.
+var OrganizationReadOnlyFields = []string{"id", "created_at"}
+
+type Member struct {
+	UserID   int64     `json:"user_id"`
+	Name     string    `json:"name"`
+	Email    string    `json:"email"`
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+func ValidateOrganization(v *validator.Validator, org *Organization) {
+	v.Check(org.Name != "", "name", validator.Required)
+	v.Check(len(org.Name) <= 100, "name", validator.MaxBytes(100))
+
+	v.Check(org.Slug != "", "slug", validator.Required)
+	v.Check(len(org.Slug) >= 3, "slug", validator.MinBytes(3))
+	v.Check(len(org.Slug) <= 50, "slug", validator.MaxBytes(50))
+	v.Check(validator.Matches(org.Slug, SlugRX), "slug", validator.NewMessage("invalid_slug"))
+
+	v.Check(len(org.Description) <= 2000, "description", validator.MaxBytes(2000))
+	v.Check(len(org.LogoURL) <= 500, "logo_url", validator.MaxBytes(500))
+	v.Check(org.LogoURL == "" || validator.HTTPURL(org.LogoURL), "logo_url", validator.NewMessage("invalid_url"))
+
+	for day, hours := range org.OpeningHours {
+		v.Check(validator.PermittedValue(day, weekdays...), "opening_hours", validator.OnlyOf(weekdays...))
+		v.Check(validator.Matches(hours, OpeningHoursRX), "opening_hours", validator.NewMessage("invalid_opening_hours"))
+	}
+}
+
+func ValidateRole(v *validator.Validator, role string) {
+	v.Check(validator.PermittedValue(role, Roles...), "role", validator.OneOf(Roles...))
+}
+
+type OrganizationModel struct {
+	DB *sql.DB
+}
+
+// Insert creates the organization and makes ownerID its first owner in one transaction.
+func (m OrganizationModel) Insert(ctx context.Context, org *Organization, ownerID int64, audit *AuditEntry) error {
+	query := `
+		insert into organizations (name, slug, description, logo_url, opening_hours)
+		values ($1, $2, $3, $4, $5)
+		returning id, created_at, version
+	`
+
+	openingHours, err := json.Marshal(org.OpeningHours)
+	if err != nil {
+		return err
+	}
+	args := []any{org.Name, org.Slug, org.Description, org.LogoURL, openingHours}
+
+	ctx, cancel := queryContext(ctx, "OrganizationModel.Insert", 3*time.Second)
+	defer cancel()
+
+	return inTransaction(ctx, m.DB, audit, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, query, args...).Scan(&org.ID, &org.CreatedAt, &org.Version)
+		if err != nil {
+			if isUniqueViolation(err, "organizations_slug_key") {
+				return ErrDuplicateSlug
+			}
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			insert into organization_members (organization_id, user_id, role)
+			values ($1, $2, $3)
+		`, org.ID, ownerID, RoleOwner)
+		if err != nil {
+			return err
+		}
+
+		if audit != nil {
+			audit.EntityID = org.ID
+		}
+		return nil
+	})
+}
+
+func (m OrganizationModel) GetBySlug(ctx context.Context, slug string) (*Organization, error) {
+	query := `
+		select id, created_at, name, slug, description, logo_url, opening_hours, version
+		from organizations
+		where slug = $1
+	`
+
+	var org Organization
+	var openingHours []byte
+
+	ctx, cancel := queryContext(ctx, "OrganizationModel.GetBySlug", 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, slug).Scan(
+		&org.ID,
+		&org.CreatedAt,
+		&org.Name,
+		&org.Slug,
+		&org.Description,
+		&org.LogoURL,
+		&openingHours,
+		&org.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	err = json.Unmarshal(openingHours, &org.OpeningHours)
+	if err != nil {
+		return nil, err
+	}
+
+	return &org, nil
+}
+
+func (m OrganizationModel) Update(ctx context.Context, org *Organization, audit *AuditEntry) error {
+	query := `
+		update organizations
+		set name = $1, slug = $2, description = $3, logo_url = $4, opening_hours = $5, version = version + 1
+		where id = $6 and version = $7
+		returning version
+	`
+
+	openingHours, err := json.Marshal(org.OpeningHours)
+	if err != nil {
+		return err
+	}
+	args := []any{org.Name, org.Slug, org.Description, org.LogoURL, openingHours, org.ID, org.Version}
+
+	ctx, cancel := queryContext(ctx, "OrganizationModel.Update", 3*time.Second)
+	defer cancel()
+
+	return inTransaction(ctx, m.DB, audit, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, query, args...).Scan(&org.Version)
+		if err != nil {
+			switch {
+			case isUniqueViolation(err, "organizations_slug_key"):
+				return ErrDuplicateSlug
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrEditConflict
+			default:
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetRole returns the role of a user in an organization, ErrRecordNotFound if they are not a member.
+func (m OrganizationModel) GetRole(ctx context.Context, organizationID, userID int64) (string, error) {
+	query := `
+		select role
+		from organization_members
+		where organization_id = $1 and user_id = $2
+	`
+
+	var role string
+
+	ctx, cancel := queryContext(ctx, "OrganizationModel.GetRole", 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, organizationID, userID).Scan(&role)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return "", ErrRecordNotFound
+		default:
+			return "", err
+		}
+	}
+	return role, nil
+}
+
+func (m OrganizationModel) GetMembers(ctx context.Context, organizationID int64) ([]*Member, error) {
+	query := `
+		select users.id, users.name, users.email, organization_members.role, organization_members.created_at
+		from organization_members
+		inner join users on users.id = organization_members.user_id
+		where organization_members.organization_id = $1
+		order by organization_members.created_at, users.id
+	`
+
+	ctx, cancel := queryContext(ctx, "OrganizationModel.GetMembers", 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []*Member{}
+	for rows.Next() {
+		var member Member
+		err := rows.Scan(&member.UserID, &member.Name, &member.Email, &member.Role, &member.JoinedAt)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, &member)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+func (m OrganizationModel) AddMember(ctx context.Context, organizationID, userID int64, role string, audit *AuditEntry) error {
+	query := `
+		insert into organization_members (organization_id, user_id, role)
+		values ($1, $2, $3)
+	`
+
+	ctx, cancel := queryContext(ctx, "OrganizationModel.AddMember", 3*time.Second)
+	defer cancel()
+
+	return inTransaction(ctx, m.DB, audit, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, query, organizationID, userID, role)
+		if err != nil {
+			if isUniqueViolation(err, "organization_members_pkey") {
+				return ErrDuplicateMember
+			}
+			return err
+		}
+		return nil
+	})
+}
+
+// RemoveMember refuses to remove the last owner, an organization always keeps someone who can manage it.
+// The owners are locked first, so two concurrent removals can't each count the other owner.
+func (m OrganizationModel) RemoveMember(ctx context.Context, organizationID, userID int64, audit *AuditEntry) error {
+	query := `
+		delete from organization_members
+		where organization_id = $1 and user_id = $2
+		and (
+			role <> 'owner' or
+			(select count(*) from organization_members where organization_id = $1 and role = 'owner') > 1
+		)
+	`
+
+	ctx, cancel := queryContext(ctx, "OrganizationModel.RemoveMember", 3*time.Second)
+	defer cancel()
+
+	err := inTransaction(ctx, m.DB, audit, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `select 1 from organization_members where organization_id = $1 and role = 'owner' for update`, organizationID)
+		if err != nil {
+			return err
+		}
+
+		result, err := tx.ExecContext(ctx, query, organizationID, userID)
+		if err != nil {
+			return err
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rowsAffected == 0 {
+			return ErrLastOwner
+		}
+		return nil
+	})
+	if errors.Is(err, ErrLastOwner) {
+		// nothing was deleted, either because of the last owner or because there is no such member
+		_, err := m.GetRole(ctx, organizationID, userID)
+		if err != nil {
+			return err
+		}
+		return ErrLastOwner
+	}
+	return err
+}
+
+func isUniqueViolation(err error, constraint string) bool {
+	var pqError *pq.Error
+	return errors.As(err, &pqError) && pqError.Code == "23505" && pqError.Constraint == constraint
+}
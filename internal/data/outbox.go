@@ -0,0 +1,333 @@
+package data
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"slices"
+	"time"
+)
+
+const (
+	OutboxStatusPending = "pending"
+	OutboxStatusSent    = "sent"
+	OutboxStatusDead    = "dead"
+
+	// MaxOutboxAttempts is how many times a message is tried before it is dead-lettered.
+	MaxOutboxAttempts = 8
+
+	outboxBaseBackoff = 30 * time.Second
+	outboxMaxBackoff  = 6 * time.Hour
+	// outboxLease is how long a claimed message is left to the worker that claimed it.
+	outboxLease = 15 * time.Minute
+)
+
+var ErrOutboxMessageDead = errors.New("outbox message is dead")
+
+var OutboxStatuses = []string{OutboxStatusPending, OutboxStatusSent, OutboxStatusDead}
+
+// OutboxMessage is an email waiting to be sent by the outbox worker. Data holds the template
+// variables, it may contain secrets like activation tokens, so it is never exposed and is
+// cleared once the message is sent or dead-lettered.
+type OutboxMessage struct {
+	ID            int64          `json:"id"`
+	CreatedAt     time.Time      `json:"created_at"`
+	Recipient     string         `json:"recipient"`
+	Locale        string         `json:"locale"`
+	Template      string         `json:"template"`
+	RequestID     string         `json:"request_id,omitempty"`
+	Data          map[string]any `json:"-"`
+	Status        string         `json:"status"`
+	Attempts      int            `json:"attempts"`
+	NextAttemptAt time.Time      `json:"next_attempt_at"`
+	LastError     string         `json:"last_error,omitempty"`
+	SentAt        *time.Time     `json:"sent_at,omitempty"`
+}
+
+// OutboxBackoff is the delay before the next attempt after attempts failed ones,
+// it doubles with every attempt up to outboxMaxBackoff.
+func OutboxBackoff(attempts int) time.Duration {
+	backoff := outboxBaseBackoff
+	for i := 1; i < attempts && backoff < outboxMaxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, outboxMaxBackoff)
+}
+
+type OutboxModel struct {
+	DB *sql.DB
+}
+
+func (m OutboxModel) Insert(ctx context.Context, message *OutboxMessage) error {
+	ctx, cancel := queryContext(ctx, "OutboxModel.Insert", 3*time.Second)
+	defer cancel()
+
+	return inTransaction(ctx, m.DB, nil, func(tx *sql.Tx) error {
+		return insertOutboxMessage(ctx, tx, message)
+	})
+}
+
+// insertOutboxMessage enqueues the message in the transaction of the change it belongs to.
+func insertOutboxMessage(ctx context.Context, tx *sql.Tx, message *OutboxMessage) error {
+	query := `
+		insert into outbox_messages (recipient, locale, template, data, request_id)
+		values ($1, $2, $3, $4, $5)
+		returning id, created_at, status, next_attempt_at
+	`
+
+	data, err := json.Marshal(message.Data)
+	if err != nil {
+		return err
+	}
+
+	return tx.QueryRowContext(ctx, query, message.Recipient, message.Locale, message.Template, data, message.RequestID).Scan(
+		&message.ID,
+		&message.CreatedAt,
+		&message.Status,
+		&message.NextAttemptAt,
+	)
+}
+
+// DeliverDue claims up to limit messages that are due and calls send for each of them. A claim
+// is a lease: it locks the messages for outboxLease and is committed right away, so no
+// transaction or row lock is held while the messages are sent, and other instances skip the
+// claimed messages. Every message is marked on its own after it was sent, a message whose
+// worker died before that becomes due again when the lease is over. A failed message is retried
+// with OutboxBackoff and moved to the dead status after MaxOutboxAttempts.
+func (m OutboxModel) DeliverDue(ctx context.Context, limit int, send func(*OutboxMessage) error) (sent, failed int, err error) {
+	messages, err := m.claimDue(ctx, limit)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, message := range messages {
+		sendError := send(message)
+		if sendError == nil {
+			err = m.markSent(ctx, message)
+			if err != nil {
+				return sent, failed, err
+			}
+			sent++
+			continue
+		}
+
+		err = m.markFailed(ctx, message, sendError)
+		if err != nil {
+			return sent, failed, err
+		}
+		failed++
+	}
+
+	return sent, failed, nil
+}
+
+func (m OutboxModel) claimDue(ctx context.Context, limit int) ([]*OutboxMessage, error) {
+	query := `
+		update outbox_messages
+		set locked_until = now() + $2 * interval '1 second'
+		where id in (
+			select id
+			from outbox_messages
+			where status = 'pending' and next_attempt_at <= now()
+			and (locked_until is null or locked_until <= now())
+			order by next_attempt_at, id
+			limit $1
+			for update skip locked
+		)
+		returning id, created_at, recipient, locale, template, data, request_id, status, attempts, next_attempt_at
+	`
+
+	ctx, cancel := queryContext(ctx, "OutboxModel.claimDue", 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, limit, int64(outboxLease.Seconds()))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []*OutboxMessage{}
+	for rows.Next() {
+		var message OutboxMessage
+		var data []byte
+
+		err := rows.Scan(
+			&message.ID,
+			&message.CreatedAt,
+			&message.Recipient,
+			&message.Locale,
+			&message.Template,
+			&data,
+			&message.RequestID,
+			&message.Status,
+			&message.Attempts,
+			&message.NextAttemptAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		// numbers stay json.Number, so that ids are not printed as floats by the templates
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		err = decoder.Decode(&message.Data)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, &message)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// the update returns the rows in no particular order
+	slices.SortFunc(messages, func(a, b *OutboxMessage) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+
+	return messages, nil
+}
+
+func (m OutboxModel) markSent(ctx context.Context, message *OutboxMessage) error {
+	query := `
+		update outbox_messages
+		set status = 'sent', attempts = attempts + 1, sent_at = now(), last_error = '', data = '{}', locked_until = null
+		where id = $1
+	`
+
+	ctx, cancel := queryContext(ctx, "OutboxModel.markSent", 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, message.ID)
+	return err
+}
+
+// markFailed schedules the next attempt, a dead message loses its data like a sent one.
+func (m OutboxModel) markFailed(ctx context.Context, message *OutboxMessage, sendError error) error {
+	query := `
+		update outbox_messages
+		set
+			status = $2,
+			attempts = $3,
+			next_attempt_at = now() + $4 * interval '1 second',
+			last_error = $5,
+			data = case when $2 = 'dead' then '{}' else data end,
+			locked_until = null
+		where id = $1
+	`
+
+	message.Attempts++
+	message.Status = OutboxStatusPending
+	if message.Attempts >= MaxOutboxAttempts {
+		message.Status = OutboxStatusDead
+	}
+
+	ctx, cancel := queryContext(ctx, "OutboxModel.markFailed", 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, message.ID, message.Status, message.Attempts, int64(OutboxBackoff(message.Attempts).Seconds()), sendError.Error())
+	return err
+}
+
+// GetAll lists messages in the status, or in every status if it is empty, newest first.
+func (m OutboxModel) GetAll(ctx context.Context, status string, filters Filters) ([]*OutboxMessage, Metadata, error) {
+	query := `
+		select count(*) over(), id, created_at, recipient, locale, template, request_id, status, attempts, next_attempt_at, last_error, sent_at
+		from outbox_messages
+		where $1 = '' or status = $1
+		order by created_at desc, id desc
+		limit $2 offset $3
+	`
+
+	ctx, cancel := queryContext(ctx, "OutboxModel.GetAll", 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, status, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	messages := []*OutboxMessage{}
+
+	for rows.Next() {
+		var message OutboxMessage
+		err := rows.Scan(
+			&totalRecords,
+			&message.ID,
+			&message.CreatedAt,
+			&message.Recipient,
+			&message.Locale,
+			&message.Template,
+			&message.RequestID,
+			&message.Status,
+			&message.Attempts,
+			&message.NextAttemptAt,
+			&message.LastError,
+			&message.SentAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		messages = append(messages, &message)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return messages, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// Retry makes a pending message that failed before due right away. Dead messages have lost
+// their data and can not be retried, ErrOutboxMessageDead is returned for them.
+func (m OutboxModel) Retry(ctx context.Context, id int64) (*OutboxMessage, error) {
+	query := `
+		update outbox_messages
+		set next_attempt_at = now()
+		where id = $1 and status = 'pending'
+		returning id, created_at, recipient, locale, template, request_id, status, attempts, next_attempt_at, last_error, sent_at
+	`
+
+	var message OutboxMessage
+
+	ctx, cancel := queryContext(ctx, "OutboxModel.Retry", 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&message.ID,
+		&message.CreatedAt,
+		&message.Recipient,
+		&message.Locale,
+		&message.Template,
+		&message.RequestID,
+		&message.Status,
+		&message.Attempts,
+		&message.NextAttemptAt,
+		&message.LastError,
+		&message.SentAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			var status string
+			err = m.DB.QueryRowContext(ctx, `select status from outbox_messages where id = $1`, id).Scan(&status)
+			switch {
+			case err == nil && status == OutboxStatusDead:
+				return nil, ErrOutboxMessageDead
+			case err == nil || errors.Is(err, sql.ErrNoRows):
+				return nil, ErrRecordNotFound
+			default:
+				return nil, err
+			}
+		default:
+			return nil, err
+		}
+	}
+
+	return &message, nil
+}
@@ -0,0 +1,28 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOutboxBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, 30 * time.Second},
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{MaxOutboxAttempts, 64 * time.Minute},
+		{10, 256 * time.Minute},
+		{11, 6 * time.Hour},
+		{1000, 6 * time.Hour},
+	}
+
+	for _, tt := range tests {
+		if got := OutboxBackoff(tt.attempts); got != tt.want {
+			t.Errorf("OutboxBackoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
@@ -15,7 +15,7 @@ type PermissionModel struct {
 	DB *sql.DB
 }
 
-func (permModel PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
+func (permModel PermissionModel) GetAllForUser(ctx context.Context, userID int64) (Permissions, error) {
 	query := `
 		select p.code
 		from permissions p
@@ -24,7 +24,7 @@ func (permModel PermissionModel) GetAllForUser(userID int64) (Permissions, error
 		where u.id = $1
 	`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(ctx, "PermissionModel.GetAllForUser", 3*time.Second)
 	defer cancel()
 
 	rows, err := permModel.DB.QueryContext(ctx, query, userID)
@@ -52,15 +52,17 @@ func (p Permissions) Include(code string) bool {
 	return slices.Contains(p, code)
 }
 
-func (permModel PermissionModel) AddForUser(userID int64, codes ...string) error {
+func (permModel PermissionModel) AddForUser(ctx context.Context, userID int64, audit *AuditEntry, codes ...string) error {
 	query := `
 		insert into users_permissions
 		select $1, permissions.id from permissions where permissions.code = ANY($2)
 	`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(ctx, "PermissionModel.AddForUser", 3*time.Second)
 	defer cancel()
 
-	_, err := permModel.DB.ExecContext(ctx, query, userID, pq.Array(codes))
-	return err
+	return inTransaction(ctx, permModel.DB, audit, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, query, userID, pq.Array(codes))
+		return err
+	})
 }
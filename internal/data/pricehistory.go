@@ -0,0 +1,45 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+type PriceChange struct {
+	Price     Price     `json:"price"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// GetPriceHistory returns every price an ad had, oldest first, the first entry is the price it was posted with.
+func (ad AdModel) GetPriceHistory(ctx context.Context, id int64) ([]*PriceChange, error) {
+	query := `
+		select price, changed_at
+		from ad_price_history
+		where ad_id = $1
+		order by changed_at, id
+	`
+
+	ctx, cancel := queryContext(ctx, "AdModel.GetPriceHistory", 3*time.Second)
+	defer cancel()
+
+	rows, err := ad.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := []*PriceChange{}
+	for rows.Next() {
+		var change PriceChange
+		err := rows.Scan(&change.Price, &change.ChangedAt)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, &change)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return history, nil
+}
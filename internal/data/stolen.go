@@ -0,0 +1,259 @@
+package data
+
+import (
+	"antipinegor/cyclingmarket/internal/validator"
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	StolenSourceUser   = "user"
+	StolenSourceImport = "import"
+
+	// reports of users wait for a moderator, only approved reports block listings
+	StolenStatusPending  = "pending"
+	StolenStatusApproved = "approved"
+	StolenStatusRejected = "rejected"
+)
+
+var StolenStatuses = []string{StolenStatusPending, StolenStatusApproved, StolenStatusRejected}
+
+var (
+	ErrDuplicateSerial = errors.New("duplicate serial")
+)
+
+type StolenBike struct {
+	ID          int64     `json:"id"`
+	CreatedAt   time.Time `json:"reported_at"`
+	Serial      string    `json:"serial"`
+	Description string    `json:"description"`
+	Source      string    `json:"source"`
+	Status      string    `json:"status"`
+	ReportedBy  int64     `json:"-"`
+}
+
+// NormalizeSerial brings a frame serial number to the form it is stored and matched in:
+// upper case with spaces, dashes and other separators removed, so "wtu 123-45a" and "WTU12345A" match.
+func NormalizeSerial(serial string) string {
+	var sb strings.Builder
+	for _, r := range serial {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			sb.WriteRune(unicode.ToUpper(r))
+		}
+	}
+	return sb.String()
+}
+
+func ValidateSerial(v *validator.Validator, serial string) {
+	v.Check(serial != "", "serial", validator.Required)
+	v.Check(len(serial) >= 4, "serial", validator.MinChars(4))
+	v.Check(len(serial) <= 64, "serial", validator.MaxBytes(64))
+}
+
+func ValidateStolenBike(v *validator.Validator, bike *StolenBike) {
+	ValidateSerial(v, bike.Serial)
+	v.Check(len(bike.Description) <= 500, "description", validator.MaxBytes(500))
+	v.Check(validator.PermittedValue(bike.Source, StolenSourceUser, StolenSourceImport), "source", validator.OneOf(StolenSourceUser, StolenSourceImport))
+}
+
+type StolenBikeModel struct {
+	DB *sql.DB
+}
+
+// Insert stores a report of a user, it is pending until a moderator reviews it. A serial with a
+// pending or approved report can't be reported again.
+func (m StolenBikeModel) Insert(ctx context.Context, bike *StolenBike) error {
+	query := `
+		insert
+		into stolen_bikes (serial, description, source, status, reported_by)
+		values ($1, $2, $3, 'pending', $4)
+		on conflict (serial) where status <> 'rejected' do nothing
+		returning id, created_at, status
+	`
+	args := []any{bike.Serial, bike.Description, bike.Source, nullInt64(bike.ReportedBy)}
+
+	ctx, cancel := queryContext(ctx, "StolenBikeModel.Insert", 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&bike.ID, &bike.CreatedAt, &bike.Status)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrDuplicateSerial
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// InsertBatch stores bikes from an imported list in a single transaction. Imports come from
+// trusted sources and are approved right away. Serials that are already in the registry are
+// skipped, the number of newly added rows is returned.
+func (m StolenBikeModel) InsertBatch(ctx context.Context, bikes []*StolenBike) (int, error) {
+	query := `
+		insert
+		into stolen_bikes (serial, description, source, status)
+		values ($1, $2, $3, 'approved')
+		on conflict (serial) where status <> 'rejected' do nothing
+	`
+
+	ctx, cancel := queryContext(ctx, "StolenBikeModel.InsertBatch", 30*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	inserted := 0
+	for _, bike := range bikes {
+		result, err := stmt.ExecContext(ctx, bike.Serial, bike.Description, bike.Source)
+		if err != nil {
+			return 0, err
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		inserted += int(rowsAffected)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return inserted, nil
+}
+
+// GetBySerial returns the approved report of the serial, reports under review are not public.
+func (m StolenBikeModel) GetBySerial(ctx context.Context, serial string) (*StolenBike, error) {
+	query := `
+		select
+			id, created_at, serial, description, source, status, coalesce(reported_by, 0)
+		from
+			stolen_bikes
+		where
+			serial = $1 and status = 'approved'
+	`
+	var bike StolenBike
+
+	ctx, cancel := queryContext(ctx, "StolenBikeModel.GetBySerial", 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, serial).Scan(
+		&bike.ID,
+		&bike.CreatedAt,
+		&bike.Serial,
+		&bike.Description,
+		&bike.Source,
+		&bike.Status,
+		&bike.ReportedBy,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &bike, nil
+}
+
+func (m StolenBikeModel) IsStolen(ctx context.Context, serial string) (bool, error) {
+	query := `
+		select exists(select 1 from stolen_bikes where serial = $1 and status = 'approved')
+	`
+	var stolen bool
+
+	ctx, cancel := queryContext(ctx, "StolenBikeModel.IsStolen", 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, serial).Scan(&stolen)
+	return stolen, err
+}
+
+// GetAll lists reports for moderators, oldest first so the queue is worked off in order.
+func (m StolenBikeModel) GetAll(ctx context.Context, status string, filters Filters) ([]*StolenBike, Metadata, error) {
+	query := `
+		select count(*) over(), id, created_at, serial, description, source, status, coalesce(reported_by, 0)
+		from stolen_bikes
+		where $1 = '' or status = $1
+		order by created_at, id
+		limit $2 offset $3
+	`
+
+	ctx, cancel := queryContext(ctx, "StolenBikeModel.GetAll", 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, status, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	bikes := []*StolenBike{}
+
+	for rows.Next() {
+		var bike StolenBike
+		err := rows.Scan(&totalRecords, &bike.ID, &bike.CreatedAt, &bike.Serial, &bike.Description, &bike.Source, &bike.Status, &bike.ReportedBy)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		bikes = append(bikes, &bike)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return bikes, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// Review approves or rejects a pending report. Reports that were already reviewed are not
+// found, so two moderators can't overrule each other by accident.
+func (m StolenBikeModel) Review(ctx context.Context, bike *StolenBike, moderatorID int64) error {
+	query := `
+		update stolen_bikes
+		set status = $2, reviewed_by = $3, reviewed_at = now()
+		where id = $1 and status = 'pending'
+		returning created_at, serial, description, source, coalesce(reported_by, 0)
+	`
+
+	ctx, cancel := queryContext(ctx, "StolenBikeModel.Review", 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, bike.ID, bike.Status, nullInt64(moderatorID)).Scan(
+		&bike.CreatedAt,
+		&bike.Serial,
+		&bike.Description,
+		&bike.Source,
+		&bike.ReportedBy,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func nullInt64(i int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: i, Valid: i != 0}
+}
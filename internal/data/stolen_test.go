@@ -0,0 +1,24 @@
+package data
+
+import "testing"
+
+func TestNormalizeSerial(t *testing.T) {
+	tests := []struct {
+		serial string
+		want   string
+	}{
+		{"WTU12345A", "WTU12345A"},
+		{"wtu 123-45a", "WTU12345A"},
+		{" WTU.123/45_A\t", "WTU12345A"},
+		{"wtu\u00a0123\u200b45a", "WTU12345A"},
+		{"вк-7", "ВК7"},
+		{"--- ...", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := NormalizeSerial(tt.serial); got != tt.want {
+			t.Errorf("NormalizeSerial(%q) = %q, want %q", tt.serial, got, tt.want)
+		}
+	}
+}
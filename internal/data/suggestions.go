@@ -0,0 +1,223 @@
+package data
+
+import (
+	"antipinegor/cyclingmarket/internal/validator"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+const (
+	// a logged query is only suggested once it was searched for MinQueryCount times by at least
+	// MinQuerySearchers people, a single search can't put private data or abuse into suggestions
+	MinQueryCount     = 5
+	MinQuerySearchers = 3
+
+	// SearchQueryRetention is how long a query is kept after it was last searched for.
+	SearchQueryRetention = 90 * 24 * time.Hour
+
+	suggestionBrand    = "brand"
+	suggestionModel    = "model"
+	suggestionCategory = "category"
+	suggestionQuery    = "query"
+)
+
+type Suggestion struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+type Suggestions struct {
+	Brands     []Suggestion `json:"brands"`
+	Models     []Suggestion `json:"models"`
+	Categories []Suggestion `json:"categories"`
+	Queries    []Suggestion `json:"queries"`
+}
+
+// NormalizeQuery lower-cases a search query and collapses whitespace, so that
+// "Shimano  105" and "shimano 105" are logged as the same query.
+func NormalizeQuery(query string) string {
+	return strings.ToLower(strings.Join(strings.Fields(query), " "))
+}
+
+func ValidateSuggestQuery(v *validator.Validator, query string) {
+	v.Check(query != "", "q", validator.Required)
+	v.Check(len([]rune(query)) >= 2, "q", validator.MinChars(2))
+	v.Check(len(query) <= 100, "q", validator.MaxBytes(100))
+}
+
+type SuggestionModel struct {
+	DB *sql.DB
+}
+
+// Suggest looks the prefix up in brands, models, categories and logged queries in a single
+// round trip, each group is limited to limit entries. The counts of listed ads come from the
+// views RefreshCounts keeps up to date, so no ads are scanned on a keystroke. Brands and models
+// also match on trigrams, so a typo in the prefix still returns something useful. Logged queries
+// are only suggested after MinQueryCount searches by MinQuerySearchers people.
+func (m SuggestionModel) Suggest(ctx context.Context, prefix string, limit int) (*Suggestions, error) {
+	query := `
+		(
+			select 'brand', brand_ad_counts.name, brand_ad_counts.count
+			from brand_ad_counts
+			where brand_ad_counts.name ilike $3::text || '%' or $1 <% brand_ad_counts.name
+			order by word_similarity($1, brand_ad_counts.name) desc, brand_ad_counts.name
+			limit $2
+		)
+		union all
+		(
+			select 'model', model_ad_counts.name, model_ad_counts.count
+			from model_ad_counts
+			where model_ad_counts.name ilike '%' || $3::text || '%' or $1 <% model_ad_counts.name
+			order by word_similarity($1, model_ad_counts.name) desc, model_ad_counts.count desc, model_ad_counts.name
+			limit $2
+		)
+		union all
+		(
+			select 'category', categories.slug, coalesce(category_ad_counts.count, 0)
+			from categories
+			left join category_ad_counts on category_ad_counts.slug = categories.slug
+			where
+				categories.slug ilike $3::text || '%' or
+				categories.names->>'en' ilike $3::text || '%' or
+				categories.names->>'ru' ilike $3::text || '%'
+			order by categories.slug
+			limit $2
+		)
+		union all
+		(
+			select 'query', search_queries.query, search_queries.count
+			from search_queries
+			where
+				search_queries.query like lower($3::text) || '%' and
+				search_queries.count >= $4 and search_queries.searchers >= $5
+			order by search_queries.count desc, search_queries.query
+			limit $2
+		)
+	`
+
+	args := []any{prefix, limit, escapeLike(prefix), MinQueryCount, MinQuerySearchers}
+
+	ctx, cancel := queryContext(ctx, "SuggestionModel.Suggest", 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	suggestions := &Suggestions{
+		Brands:     []Suggestion{},
+		Models:     []Suggestion{},
+		Categories: []Suggestion{},
+		Queries:    []Suggestion{},
+	}
+
+	for rows.Next() {
+		var kind string
+		var suggestion Suggestion
+		err := rows.Scan(&kind, &suggestion.Value, &suggestion.Count)
+		if err != nil {
+			return nil, err
+		}
+
+		switch kind {
+		case suggestionBrand:
+			suggestions.Brands = append(suggestions.Brands, suggestion)
+		case suggestionModel:
+			suggestions.Models = append(suggestions.Models, suggestion)
+		case suggestionCategory:
+			suggestions.Categories = append(suggestions.Categories, suggestion)
+		case suggestionQuery:
+			suggestions.Queries = append(suggestions.Queries, suggestion)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return suggestions, nil
+}
+
+// RefreshCounts recounts the listed ads of brands, models and categories suggestions are served
+// from. Like RemindExpiring it only runs on one API instance at a time.
+func (m SuggestionModel) RefreshCounts(ctx context.Context) error {
+	ctx, cancel := queryContext(ctx, "SuggestionModel.RefreshCounts", time.Minute)
+	defer cancel()
+
+	return inTransaction(ctx, m.DB, nil, func(tx *sql.Tx) error {
+		var locked bool
+		err := tx.QueryRowContext(ctx, `select pg_try_advisory_xact_lock(hashtext('suggestion counts'))`).Scan(&locked)
+		if err != nil || !locked {
+			return err
+		}
+
+		for _, view := range []string{"brand_ad_counts", "model_ad_counts", "category_ad_counts"} {
+			_, err = tx.ExecContext(ctx, "refresh materialized view concurrently "+view)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// LogQuery counts a search for query. The searcher identifies who searched, only its hash is
+// stored and only to count the distinct people who searched for the query.
+func (m SuggestionModel) LogQuery(ctx context.Context, query, searcher string) error {
+	hash := sha256.Sum256([]byte(searcher))
+
+	ctx, cancel := queryContext(ctx, "SuggestionModel.LogQuery", 3*time.Second)
+	defer cancel()
+
+	return inTransaction(ctx, m.DB, nil, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `
+			insert into search_queries (query)
+			values ($1)
+			on conflict (query) do update
+			set count = search_queries.count + 1, last_searched_at = now()
+		`, query)
+		if err != nil {
+			return err
+		}
+
+		var first bool
+		err = tx.QueryRowContext(ctx, `
+			insert into search_query_searchers (query, searcher)
+			values ($1, $2)
+			on conflict (query, searcher) do update
+			set last_searched_at = now()
+			returning xmax = 0
+		`, query, hash[:]).Scan(&first)
+		if err != nil || !first {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `update search_queries set searchers = searchers + 1 where query = $1`, query)
+		return err
+	})
+}
+
+// PurgeQueries forgets the queries and searchers not seen for SearchQueryRetention.
+func (m SuggestionModel) PurgeQueries(ctx context.Context) error {
+	ctx, cancel := queryContext(ctx, "SuggestionModel.PurgeQueries", 30*time.Second)
+	defer cancel()
+
+	return inTransaction(ctx, m.DB, nil, func(tx *sql.Tx) error {
+		args := []any{int64(SearchQueryRetention.Seconds())}
+
+		_, err := tx.ExecContext(ctx, `delete from search_query_searchers where last_searched_at < now() - $1 * interval '1 second'`, args...)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `delete from search_queries where last_searched_at < now() - $1 * interval '1 second'`, args...)
+		return err
+	})
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
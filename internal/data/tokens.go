@@ -41,24 +41,24 @@ func generateToken(userID int64, timeToLive time.Duration, scope string) *Token
 }
 
 func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
-	v.Check(tokenPlaintext != "", "token", "must be provided")
-	v.Check(len(tokenPlaintext) == 26, "token", "must be 26 bytes long")
+	v.Check(tokenPlaintext != "", "token", validator.Required)
+	v.Check(len(tokenPlaintext) == 26, "token", validator.ExactBytes(26))
 }
 
-func (tokenModel TokenModel) New(userID int64, timeToLive time.Duration, scope string) (*Token, error) {
+func (tokenModel TokenModel) New(ctx context.Context, userID int64, timeToLive time.Duration, scope string) (*Token, error) {
 	token := generateToken(userID, timeToLive, scope)
-	err := tokenModel.Insert(token)
+	err := tokenModel.Insert(ctx, token)
 	return token, err
 }
 
-func (tokenModel TokenModel) Insert(token *Token) error {
+func (tokenModel TokenModel) Insert(ctx context.Context, token *Token) error {
 	query := `
 		insert into tokens (hash, user_id, expiry, scope)
 		values ($1, $2, $3, $4)
 	`
 
 	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(ctx, "TokenModel.Insert", 3*time.Second)
 	defer cancel()
 
 	_, err := tokenModel.DB.ExecContext(ctx, query, args...)
@@ -66,14 +66,14 @@ func (tokenModel TokenModel) Insert(token *Token) error {
 	return err
 }
 
-func (tokenModel TokenModel) DeleteAllForUser(scope string, userID int64) error {
+func (tokenModel TokenModel) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
 	query := `
 		delete from tokens
 		where scope = $1 and user_id = $2
 	`
 
 	args := []any{scope, userID}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(ctx, "TokenModel.DeleteAllForUser", 3*time.Second)
 	defer cancel()
 
 	_, err := tokenModel.DB.ExecContext(ctx, query, args...)
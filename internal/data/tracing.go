@@ -0,0 +1,130 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("antipinegor/cyclingmarket/internal/data")
+
+// queryContext starts a span for a model method as a child of the span in parent and limits
+// its queries to timeout. cancel ends the span as well. Failed statements mark the span as
+// failed when the pool was opened with OpenDB.
+func queryContext(parent context.Context, name string, timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, span := tracer.Start(parent, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("db.system", "postgresql")),
+	)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+
+	return ctx, func() {
+		cancel()
+		span.End()
+	}
+}
+
+// recordError marks the span in ctx as failed with err and returns err.
+func recordError(ctx context.Context, err error) error {
+	if err != nil && !errors.Is(err, driver.ErrSkip) {
+		span := trace.SpanFromContext(ctx)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
+
+// OpenDB opens a PostgreSQL connection pool that records failed statements on the span of the
+// model method that ran them, the one queryContext started.
+func OpenDB(dsn string) (*sql.DB, error) {
+	connector, err := pq.NewConnector(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return sql.OpenDB(tracedConnector{connector}), nil
+}
+
+type tracedConnector struct {
+	driver.Connector
+}
+
+func (c tracedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, recordError(ctx, err)
+	}
+	if conn, ok := conn.(contextConn); ok {
+		return tracedConn{conn}, nil
+	}
+	return conn, nil
+}
+
+// contextConn is what database/sql uses of a lib/pq connection.
+type contextConn interface {
+	driver.Conn
+	driver.ConnBeginTx
+	driver.ConnPrepareContext
+	driver.ExecerContext
+	driver.QueryerContext
+	driver.Pinger
+	driver.SessionResetter
+	driver.Validator
+}
+
+type tracedConn struct {
+	contextConn
+}
+
+func (c tracedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	rows, err := c.contextConn.QueryContext(ctx, query, args)
+	return rows, recordError(ctx, err)
+}
+
+func (c tracedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	result, err := c.contextConn.ExecContext(ctx, query, args)
+	return result, recordError(ctx, err)
+}
+
+func (c tracedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	tx, err := c.contextConn.BeginTx(ctx, opts)
+	return tx, recordError(ctx, err)
+}
+
+func (c tracedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	stmt, err := c.contextConn.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, recordError(ctx, err)
+	}
+	if stmt, ok := stmt.(contextStmt); ok {
+		return tracedStmt{stmt}, nil
+	}
+	return stmt, nil
+}
+
+type contextStmt interface {
+	driver.Stmt
+	driver.StmtExecContext
+	driver.StmtQueryContext
+}
+
+type tracedStmt struct {
+	contextStmt
+}
+
+func (s tracedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	rows, err := s.contextStmt.QueryContext(ctx, args)
+	return rows, recordError(ctx, err)
+}
+
+func (s tracedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	result, err := s.contextStmt.ExecContext(ctx, args)
+	return result, recordError(ctx, err)
+}
@@ -6,9 +6,11 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"errors"
-	"fmt"
 	"time"
 
+	"github.com/lib/pq"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -16,6 +18,11 @@ var (
 	ErrDuplicateEmail = errors.New("duplicate email")
 )
 
+// DefaultLocale is the language of users who did not choose one.
+const DefaultLocale = "en"
+
+var Locales = []string{"ru", "en"}
+
 type User struct {
 	ID        int64     `json:"id"`
 	CreatedAt time.Time `json:"created_at"`
@@ -23,11 +30,19 @@ type User struct {
 	Email     string    `json:"email"`
 	Password  password  `json:"-"`
 	Activated bool      `json:"activated"`
+	Locale    string    `json:"locale"`
 	Version   int       `json:"-"`
 }
 
 var AnonymousUser = &User{}
 
+// Seller is the public part of a user shown next to their ads.
+type Seller struct {
+	ID          int64     `json:"id"`
+	Name        string    `json:"name"`
+	MemberSince time.Time `json:"member_since"`
+}
+
 func (u *User) IsAnonymous() bool {
 	return u == AnonymousUser
 }
@@ -41,38 +56,68 @@ type UserModel struct {
 	DB *sql.DB
 }
 
-func (m UserModel) Insert(user *User) error {
-	query := `
-		insert 
-		into users (name, email, password_hash, activated)
-		values ($1, $2, $3, $4)
-		returning id, created_at, version
-		`
-	args := []any{user.Name, user.Email, user.Password.hash, user.Activated}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// Register stores a new user together with its permissions, an activation token and the
+// message built by welcome in one transaction, so that the welcome email is queued if and only
+// if the user exists. The audit entry gets the id of the new user.
+func (m UserModel) Register(ctx context.Context, user *User, codes []string, activationTTL time.Duration, audit *AuditEntry, welcome func(*Token) *OutboxMessage) (*Token, error) {
+	ctx, cancel := queryContext(ctx, "UserModel.Register", 3*time.Second)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
-	if err != nil {
-		fmt.Println(err.Error())
-		switch {
-		case err.Error() == `pq: повторяющееся значение ключа нарушает ограничение уникальности "users_email_key"`:
-			return ErrDuplicateEmail
-		default:
+	var token *Token
+
+	err := inTransaction(ctx, m.DB, audit, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, `
+			insert into users (name, email, password_hash, activated, locale)
+			values ($1, $2, $3, $4, $5)
+			returning id, created_at, version
+		`, user.Name, user.Email, user.Password.hash, user.Activated, user.Locale).Scan(&user.ID, &user.CreatedAt, &user.Version)
+		if err != nil {
+			switch {
+			case isUniqueViolation(err, "users_email_key"):
+				return ErrDuplicateEmail
+			default:
+				return err
+			}
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			insert into users_permissions
+			select $1, permissions.id from permissions where permissions.code = ANY($2)
+		`, user.ID, pq.Array(codes))
+		if err != nil {
 			return err
 		}
+
+		token = generateToken(user.ID, activationTTL, ScopeActivation)
+		_, err = tx.ExecContext(ctx, `
+			insert into tokens (hash, user_id, expiry, scope)
+			values ($1, $2, $3, $4)
+		`, token.Hash, token.UserID, token.Expiry, token.Scope)
+		if err != nil {
+			return err
+		}
+
+		if audit != nil {
+			audit.EntityID = user.ID
+		}
+
+		return insertOutboxMessage(ctx, tx, welcome(token))
+	})
+	if err != nil {
+		return nil, err
 	}
-	return nil
+
+	return token, nil
 }
 
-func (m UserModel) GetByEmail(email string) (*User, error) {
+func (m UserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
 	query := `
 		select 
-		id, created_at, name, email, password_hash, activated, version
+		id, created_at, name, email, password_hash, activated, locale, version
 		from users
 		where email = $1`
 	var user User
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(ctx, "UserModel.GetByEmail", 3*time.Second)
 	defer cancel()
 	err := m.DB.QueryRowContext(ctx, query, email).Scan(
 		&user.ID,
@@ -81,6 +126,7 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
+		&user.Locale,
 		&user.Version,
 	)
 	if err != nil {
@@ -94,11 +140,11 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 	return &user, nil
 }
 
-func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error) {
+func (m UserModel) GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error) {
 	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
 
 	query := `
-		select users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version
+		select users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.locale, users.version
 		from users
 		inner join tokens on users.id = tokens.user_id
 		where
@@ -110,7 +156,7 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 
 	var user User
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(ctx, "UserModel.GetForToken", 3*time.Second)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
@@ -120,6 +166,7 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
+		&user.Locale,
 		&user.Version,
 	)
 	if err != nil {
@@ -134,40 +181,78 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 	return &user, nil
 }
 
-func (m UserModel) Update(user *User) error {
+func (m UserModel) Update(ctx context.Context, user *User, audit *AuditEntry) error {
 	query := `
 		update users
-		set name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
-		where id = $5 AND version = $6
+		set name = $1, email = $2, password_hash = $3, activated = $4, locale = $5, version = version + 1
+		where id = $6 AND version = $7
 		returning version`
 	args := []any{
 		user.Name,
 		user.Email,
 		user.Password.hash,
 		user.Activated,
+		user.Locale,
 		user.ID,
 		user.Version,
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(ctx, "UserModel.Update", 3*time.Second)
+	defer cancel()
+	return inTransaction(ctx, m.DB, audit, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, query, args...).Scan(&user.Version)
+		if err != nil {
+			switch {
+			case isUniqueViolation(err, "users_email_key"):
+				return ErrDuplicateEmail
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrEditConflict
+			default:
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (m UserModel) GetSellers(ctx context.Context, ids []int64) (map[int64]*Seller, error) {
+	query := `
+		select id, name, created_at
+		from users
+		where id = any($1)`
+
+	ctx, cancel := queryContext(ctx, "UserModel.GetSellers", 3*time.Second)
 	defer cancel()
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(ids))
 	if err != nil {
-		switch {
-		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
-			return ErrDuplicateEmail
-		case errors.Is(err, sql.ErrNoRows):
-			return ErrEditConflict
-		default:
-			return err
+		return nil, err
+	}
+	defer rows.Close()
+
+	sellers := make(map[int64]*Seller)
+	for rows.Next() {
+		var seller Seller
+		err := rows.Scan(&seller.ID, &seller.Name, &seller.MemberSince)
+		if err != nil {
+			return nil, err
 		}
+		sellers[seller.ID] = &seller
 	}
-	return nil
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sellers, nil
 }
 
-func (p *password) Set(plaintextPassword string) error {
+// Set hashes the password. bcrypt is slow on purpose, so it gets a span of its own.
+func (p *password) Set(ctx context.Context, plaintextPassword string) error {
+	ctx, span := tracer.Start(ctx, "bcrypt.GenerateFromPassword", trace.WithAttributes(attribute.Int("bcrypt.cost", 12)))
+	defer span.End()
+
 	hash, err := bcrypt.GenerateFromPassword([]byte(plaintextPassword), 12)
 	if err != nil {
-		return err
+		return recordError(ctx, err)
 	}
 	p.plaintext = &plaintextPassword
 	p.hash = hash
@@ -175,35 +260,43 @@ func (p *password) Set(plaintextPassword string) error {
 	return nil
 }
 
-func (p *password) Matches(plaintextPassword string) (bool, error) {
+func (p *password) Matches(ctx context.Context, plaintextPassword string) (bool, error) {
+	ctx, span := tracer.Start(ctx, "bcrypt.CompareHashAndPassword")
+	defer span.End()
+
 	err := bcrypt.CompareHashAndPassword(p.hash, []byte(plaintextPassword))
 	if err != nil {
 		switch {
 		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
 			return false, nil
 		default:
-			return false, err
+			return false, recordError(ctx, err)
 		}
 	}
 	return true, nil
 }
 
 func ValidateEmail(v *validator.Validator, email string) {
-	v.Check(email != "", "email", "must be provided")
-	v.Check(validator.Matches(email, validator.EmailRX), "email", "must be a valid email address")
+	v.Check(email != "", "email", validator.Required)
+	v.Check(validator.Matches(email, validator.EmailRX), "email", validator.InvalidEmail)
 }
 
 func ValidatePasswordPlaintext(v *validator.Validator, password string) {
-	v.Check(password != "", "password", "must be provided")
-	v.Check(len(password) >= 8, "password", "must be at least 8 bytes long")
-	v.Check(len(password) <= 72, "password", "must not be more than 72 bytes long")
+	v.Check(password != "", "password", validator.Required)
+	v.Check(len(password) >= 8, "password", validator.MinBytes(8))
+	v.Check(len(password) <= 72, "password", validator.MaxBytes(72))
+}
+
+func ValidateLocale(v *validator.Validator, locale string) {
+	v.Check(validator.PermittedValue(locale, Locales...), "locale", validator.OneOf(Locales...))
 }
 
 func ValidateUser(v *validator.Validator, user *User) {
-	v.Check(user.Name != "", "name", "must be provided")
-	v.Check(len(user.Name) <= 500, "name", "must not be more than 500 bytes long")
+	v.Check(user.Name != "", "name", validator.Required)
+	v.Check(len(user.Name) <= 500, "name", validator.MaxBytes(500))
 
 	ValidateEmail(v, user.Email)
+	ValidateLocale(v, user.Locale)
 
 	if user.Password.plaintext != nil {
 		ValidatePasswordPlaintext(v, *user.Password.plaintext)
@@ -0,0 +1,160 @@
+package i18n
+
+var catalog = map[string]map[string]string{
+	"en": {
+		// responses
+		"server_error":            "the server encountered a problem and could not process your request",
+		"not_found":               "the requested resource could not be found",
+		"method_not_allowed":      "the %s method is not supported for this resource",
+		"bad_request":             "the request could not be understood",
+		"failed_validation":       "the request contains invalid values",
+		"invalid_credentials":     "invalid authentication credentials",
+		"invalid_token":           "invalid or missing authenication token",
+		"authentication_required": "you must be authenticated to access this resource",
+		"inactive_account":        "your user account must be activated to access this resource",
+		"not_permitted":           "your user account doesn't have the necessary permissions to access this resource",
+		"edit_conflict":           "unable to update the record due to an edit conflict, someone has already changed this ad, please try again",
+		"precondition_failed":     "the ad has been changed since you fetched it, please fetch it again",
+		"unsupported_patch_type":  "the request body must be a JSON merge patch or a JSON patch document",
+		"unsupported_import_type": "the request body must be text/csv or application/x-ndjson",
+		"invalid_patch":           "the patch can not be applied",
+		"idempotency_conflict":    "a request with this idempotency key is still being processed, please retry later",
+		"idempotency_key_reused":  "this idempotency key has already been used for a different request",
+		"last_owner":              "the last owner can not be removed from the organization",
+		"outbox_message_dead":     "the message was dead-lettered and its data was cleared, it can not be retried",
+		"rate_limit_exceeded":     "rate limit exceeded",
+		"import_not_saved":        "could not be saved, please try again",
+		"export_failed":           "the export failed, the ads above are incomplete, please try again",
+		"invalid_json":            "must be a valid JSON object: %s",
+		"body_malformed_json":     "body contains badly-formed JSON",
+		"body_malformed_json_at":  "body contains badly-formed JSON (at character %d)",
+		"body_wrong_type_field":   "body contains incorrect JSON type for field %q",
+		"body_wrong_type_at":      "body contains incorrect JSON type (at character %d)",
+		"body_unknown_key":        "body contains unknown key %s",
+		"body_multiple_values":    "body must only contain a single JSON value",
+		"body_empty":              "body must not be empty",
+		"body_too_large":          "body must not be longer than %d bytes",
+		"ad_deleted":              "Ad successfully deleted",
+		"favorite_removed":        "ad successfully removed from favorites",
+		"member_removed":          "member successfully removed",
+		"image_removed":           "image successfully removed",
+
+		// validation
+		"required":                 "must be provided",
+		"positive":                 "must be greater than zero",
+		"not_negative":             "must not be negative",
+		"invalid_value":            "invalid value",
+		"no_duplicates":            "must not contain duplicate values",
+		"invalid_email":            "must be a valid email address",
+		"invalid_url":              "must be an absolute http or https URL",
+		"not_integer":              "must be an integer value",
+		"not_boolean":              "must be a boolean value",
+		"not_timestamp":            "must be an RFC 3339 timestamp",
+		"read_only":                "can not be changed",
+		"min_bytes":                "must be at least %d bytes long",
+		"max_bytes":                "must not be more than %d bytes long",
+		"exact_bytes":              "must be %d bytes long",
+		"min_chars":                "must be at least %d characters long",
+		"max_value":                "must be a maximum of %d",
+		"min_items":                "must contain at least %d values",
+		"max_items":                "must not contain more than %d values",
+		"one_of":                   "must be one of %s",
+		"only_of":                  "must contain only %s",
+		"min_percent":              "must be at least %d percent",
+		"max_percent":              "must not be more than %d percent",
+		"stolen_frame_serial":      "this frame serial number is reported as stolen",
+		"duplicate_stolen_report":  "this frame serial number is already reported as stolen",
+		"duplicate_email":          "user with this email address already exists",
+		"invalid_activation_token": "invalid or expired activation token",
+		"duplicate_slug":           "an organization with this slug already exists",
+		"invalid_slug":             "must contain only lowercase letters, digits and dashes",
+		"invalid_opening_hours":    `must have "HH:MM-HH:MM" or "closed" values`,
+		"unknown_email":            "no user with this email address",
+		"unknown_organization":     "no organization with this slug",
+		"duplicate_member":         "this user is already a member of the organization",
+		"unknown_category":         "must contain only known categories, see /v1/categories",
+		"after_from":               "must be after from",
+		"invalid_cursor":           "must be a cursor returned in next_cursor",
+		"page_with_cursor":         "must not be used together with cursor",
+		"cursor_sort_mismatch":     "was issued for a different sort value",
+		"too_many_images":          "an ad can not have more than %d images",
+	},
+	"ru": {
+		// responses
+		"server_error":            "на сервере произошла ошибка, запрос не удалось обработать",
+		"not_found":               "запрошенный ресурс не найден",
+		"method_not_allowed":      "метод %s не поддерживается для этого ресурса",
+		"bad_request":             "не удалось разобрать запрос",
+		"failed_validation":       "запрос содержит недопустимые значения",
+		"invalid_credentials":     "неверные учётные данные",
+		"invalid_token":           "токен аутентификации недействителен или отсутствует",
+		"authentication_required": "для доступа к этому ресурсу необходимо войти",
+		"inactive_account":        "для доступа к этому ресурсу аккаунт должен быть активирован",
+		"not_permitted":           "у вашего аккаунта нет прав на доступ к этому ресурсу",
+		"edit_conflict":           "не удалось сохранить изменения: объявление уже кто-то изменил, попробуйте ещё раз",
+		"precondition_failed":     "объявление изменилось с момента загрузки, загрузите его заново",
+		"unsupported_patch_type":  "тело запроса должно быть документом JSON merge patch или JSON patch",
+		"unsupported_import_type": "тело запроса должно быть в формате text/csv или application/x-ndjson",
+		"invalid_patch":           "патч невозможно применить",
+		"idempotency_conflict":    "запрос с этим ключом идемпотентности ещё обрабатывается, повторите позже",
+		"idempotency_key_reused":  "этот ключ идемпотентности уже использован для другого запроса",
+		"last_owner":              "нельзя удалить последнего владельца организации",
+		"outbox_message_dead":     "сообщение перемещено в недоставленные, его данные удалены, повторить отправку нельзя",
+		"rate_limit_exceeded":     "превышен лимит запросов",
+		"import_not_saved":        "не удалось сохранить, попробуйте ещё раз",
+		"export_failed":           "экспорт прервался, список объявлений выше неполный, попробуйте ещё раз",
+		"invalid_json":            "должно быть корректным JSON-объектом: %s",
+		"body_malformed_json":     "тело запроса содержит некорректный JSON",
+		"body_malformed_json_at":  "тело запроса содержит некорректный JSON (на символе %d)",
+		"body_wrong_type_field":   "тело запроса содержит значение неверного типа в поле %q",
+		"body_wrong_type_at":      "тело запроса содержит значение неверного типа (на символе %d)",
+		"body_unknown_key":        "тело запроса содержит неизвестный ключ %s",
+		"body_multiple_values":    "тело запроса должно содержать только одно JSON-значение",
+		"body_empty":              "тело запроса не должно быть пустым",
+		"body_too_large":          "тело запроса не должно быть длиннее %d байт",
+		"ad_deleted":              "объявление удалено",
+		"favorite_removed":        "объявление удалено из избранного",
+		"member_removed":          "участник удалён из организации",
+		"image_removed":           "изображение удалено",
+
+		// validation
+		"required":                 "обязательное поле",
+		"positive":                 "должно быть больше нуля",
+		"not_negative":             "не должно быть отрицательным",
+		"invalid_value":            "недопустимое значение",
+		"no_duplicates":            "не должно содержать повторяющихся значений",
+		"invalid_email":            "должно быть корректным адресом электронной почты",
+		"invalid_url":              "должно быть абсолютным URL с http или https",
+		"not_integer":              "должно быть целым числом",
+		"not_boolean":              "должно быть логическим значением",
+		"not_timestamp":            "должно быть временем в формате RFC 3339",
+		"read_only":                "нельзя изменить",
+		"min_bytes":                "должно быть не короче %d байт",
+		"max_bytes":                "должно быть не длиннее %d байт",
+		"exact_bytes":              "должно быть длиной %d байт",
+		"min_chars":                "должно содержать не менее %d символов",
+		"max_value":                "должно быть не больше %d",
+		"min_items":                "должно содержать не менее %d значений",
+		"max_items":                "должно содержать не более %d значений",
+		"one_of":                   "должно быть одним из значений: %s",
+		"only_of":                  "может содержать только значения: %s",
+		"min_percent":              "должно быть не меньше %d процентов",
+		"max_percent":              "должно быть не больше %d процентов",
+		"stolen_frame_serial":      "велосипед с этим номером рамы числится украденным",
+		"duplicate_stolen_report":  "о краже велосипеда с этим номером рамы уже сообщили",
+		"duplicate_email":          "пользователь с таким адресом электронной почты уже существует",
+		"invalid_activation_token": "токен активации недействителен или истёк",
+		"duplicate_slug":           "организация с таким адресом уже существует",
+		"invalid_slug":             "может содержать только строчные латинские буквы, цифры и дефисы",
+		"invalid_opening_hours":    `значения должны быть в формате "HH:MM-HH:MM" или "closed"`,
+		"unknown_email":            "нет пользователя с таким адресом электронной почты",
+		"unknown_organization":     "нет организации с таким адресом",
+		"duplicate_member":         "пользователь уже состоит в организации",
+		"unknown_category":         "может содержать только известные категории, см. /v1/categories",
+		"after_from":               "должно быть позже from",
+		"invalid_cursor":           "должно быть курсором из next_cursor",
+		"page_with_cursor":         "нельзя использовать вместе с cursor",
+		"cursor_sort_mismatch":     "был выдан для другой сортировки",
+		"too_many_images":          "у объявления не может быть больше %d изображений",
+	},
+}
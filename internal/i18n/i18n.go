@@ -0,0 +1,53 @@
+// Package i18n holds the texts of API messages by locale and stable message code.
+package i18n
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DefaultLocale is used when the client accepts none of the supported locales.
+const DefaultLocale = "en"
+
+// Negotiate picks the supported locale the client prefers most in an Accept-Language header,
+// e.g. "ru-RU,ru;q=0.9,en;q=0.8". Region subtags are ignored, a q of 0 excludes a language.
+func Negotiate(acceptLanguage string) string {
+	best, bestQ := DefaultLocale, 0.0
+
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+
+		language, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		if _, ok := catalog[language]; ok && q > bestQ {
+			best, bestQ = language, q
+		}
+	}
+
+	return best
+}
+
+// Text formats the message with the code in the locale. Codes missing in the locale fall back
+// to DefaultLocale, unknown codes are returned as they are.
+func Text(locale, code string, args ...any) string {
+	format, ok := catalog[locale][code]
+	if !ok {
+		format, ok = catalog[DefaultLocale][code]
+	}
+	if !ok {
+		return code
+	}
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
@@ -0,0 +1,52 @@
+package i18n
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		acceptLanguage string
+		want           string
+	}{
+		{"", DefaultLocale},
+		{"ru", "ru"},
+		{"ru-RU,ru;q=0.9,en;q=0.8", "ru"},
+		{"de-DE,en;q=0.5,ru;q=0.7", "ru"},
+		{"EN-us", "en"},
+		{"ru;q=0", DefaultLocale},
+		{"ru;q=abc,en;q=0.1", "en"},
+		{"de,fr", DefaultLocale},
+	}
+
+	for _, tt := range tests {
+		if got := Negotiate(tt.acceptLanguage); got != tt.want {
+			t.Errorf("Negotiate(%q) = %q, want %q", tt.acceptLanguage, got, tt.want)
+		}
+	}
+}
+
+var verbRX = regexp.MustCompile(`%[-+# 0-9.]*[a-zA-Z%]`)
+
+// Every locale translates every code and formats the same arguments.
+func TestCatalog(t *testing.T) {
+	for locale, messages := range catalog {
+		for code, format := range catalog[DefaultLocale] {
+			translated, ok := messages[code]
+			if !ok {
+				t.Errorf("%s: code %s is missing", locale, code)
+				continue
+			}
+			want, got := verbRX.FindAllString(format, -1), verbRX.FindAllString(translated, -1)
+			if len(want) != len(got) {
+				t.Errorf("%s: code %s formats %v, want %v", locale, code, got, want)
+			}
+		}
+		for code := range messages {
+			if _, ok := catalog[DefaultLocale][code]; !ok {
+				t.Errorf("%s: code %s is missing in %s", locale, code, DefaultLocale)
+			}
+		}
+	}
+}
@@ -0,0 +1,59 @@
+// Package jsonpatch applies RFC 7386 merge patches and RFC 6902 JSON patches on top of
+// github.com/evanphx/json-patch and maps its failures to the errors the handlers respond to.
+package jsonpatch
+
+import (
+	"errors"
+	"fmt"
+
+	evanphx "github.com/evanphx/json-patch/v5"
+)
+
+const (
+	MergePatchType = "application/merge-patch+json"
+	JSONPatchType  = "application/json-patch+json"
+
+	// maxCopySize limits how much copy operations may grow a document, a small patch could
+	// otherwise copy a large member over and over.
+	maxCopySize = 1_048_576
+)
+
+var (
+	ErrMalformedPatch    = errors.New("malformed patch document")
+	ErrInapplicablePatch = errors.New("patch can not be applied")
+	ErrTestFailed        = errors.New("patch test operation failed")
+)
+
+// MergePatch applies an RFC 7386 merge patch to a JSON document: objects are merged
+// recursively, null removes a member and any other value replaces the target.
+func MergePatch(document, patch []byte) ([]byte, error) {
+	patched, err := evanphx.MergePatch(document, patch)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedPatch, err)
+	}
+	return patched, nil
+}
+
+// Apply applies an RFC 6902 JSON patch to a JSON document. Operations are applied in order
+// and the patch is atomic: on any error the original document is left as it was.
+func Apply(document, patch []byte) ([]byte, error) {
+	operations, err := evanphx.DecodePatch(patch)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedPatch, err)
+	}
+
+	options := evanphx.NewApplyOptions()
+	options.SupportNegativeIndices = false
+	options.AccumulatedCopySizeLimit = maxCopySize
+
+	patched, err := operations.ApplyWithOptions(document, options)
+	if err != nil {
+		switch {
+		case errors.Is(err, evanphx.ErrTestFailed):
+			return nil, fmt.Errorf("%w: %v", ErrTestFailed, err)
+		default:
+			return nil, fmt.Errorf("%w: %v", ErrInapplicablePatch, err)
+		}
+	}
+	return patched, nil
+}
@@ -2,66 +2,152 @@ package mailer
 
 import (
 	"bytes"
+	"context"
 	"embed"
+	"fmt"
 	ht "html/template"
+	"io/fs"
+	"path"
 	tt "text/template"
-	"time"
 
 	"github.com/wneessen/go-mail"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = otel.Tracer("antipinegor/cyclingmarket/internal/mailer")
+
 //go:embed "templates"
 var templateFS embed.FS
 
+// FallbackLocale is used for recipients whose locale has no version of the template.
+const FallbackLocale = "en"
+
+// templateBlocks are the templates every email must define.
+var templateBlocks = []string{"subject", "plainBody", "htmlBody"}
+
+type templateSet struct {
+	text *tt.Template
+	html *ht.Template
+}
+
 type Mailer struct {
-	client *mail.Client
-	sender string
+	transport Transport
+	sender    string
+	// templates maps a locale to the parsed templates of that locale by file name.
+	templates map[string]map[string]*templateSet
 }
 
-func New(host string, port int, sender string) (*Mailer, error) {
-	client, err := mail.NewClient(
-		host,
-		mail.WithPort(port),
-		mail.WithTimeout(5*time.Second),
-		mail.WithTLSPolicy(mail.NoTLS),
-	)
+// New parses the templates of every locale directory together with templates/layout.tmpl
+// and fails if one of them does not define all templateBlocks.
+func New(transport Transport, sender string) (*Mailer, error) {
+	templates, err := parseTemplates()
 	if err != nil {
 		return nil, err
 	}
+	if len(templates[FallbackLocale]) == 0 {
+		return nil, fmt.Errorf("mailer: no templates for the fallback locale %q", FallbackLocale)
+	}
 
 	mailer := &Mailer{
-		client: client,
-		sender: sender,
+		transport: transport,
+		sender:    sender,
+		templates: templates,
 	}
 
 	return mailer, nil
 }
 
-func (m *Mailer) Send(recipient string, templateFile string, data any) error {
-	textTmpl, err := tt.New("").ParseFS(templateFS, "templates/"+templateFile)
+func parseTemplates() (map[string]map[string]*templateSet, error) {
+	locales, err := fs.ReadDir(templateFS, "templates")
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	subject := new(bytes.Buffer)
-	err = textTmpl.ExecuteTemplate(subject, "subject", data)
+	templates := make(map[string]map[string]*templateSet)
+	for _, locale := range locales {
+		if !locale.IsDir() {
+			continue
+		}
+
+		files, err := fs.Glob(templateFS, path.Join("templates", locale.Name(), "*.tmpl"))
+		if err != nil {
+			return nil, err
+		}
+
+		templates[locale.Name()] = make(map[string]*templateSet)
+		for _, file := range files {
+			patterns := []string{"templates/layout.tmpl", file}
+
+			textTmpl, err := tt.New("").ParseFS(templateFS, patterns...)
+			if err != nil {
+				return nil, err
+			}
+			htmlTmpl, err := ht.New("").ParseFS(templateFS, patterns...)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, block := range templateBlocks {
+				if textTmpl.Lookup(block) == nil {
+					return nil, fmt.Errorf("mailer: %s does not define %q", file, block)
+				}
+			}
+
+			templates[locale.Name()][path.Base(file)] = &templateSet{text: textTmpl, html: htmlTmpl}
+		}
+	}
+
+	return templates, nil
+}
+
+// template returns the template in the locale, or in FallbackLocale if the locale has none.
+func (m *Mailer) template(locale, templateFile string) (*templateSet, error) {
+	if set, ok := m.templates[locale][templateFile]; ok {
+		return set, nil
+	}
+	if set, ok := m.templates[FallbackLocale][templateFile]; ok {
+		return set, nil
+	}
+	return nil, fmt.Errorf("mailer: unknown template %q", templateFile)
+}
+
+// Send renders the template in the locale of the recipient and hands the email to the transport.
+// The span it records is a child of the span in ctx.
+func (m *Mailer) Send(ctx context.Context, recipient, locale, templateFile string, data any) (err error) {
+	ctx, span := tracer.Start(ctx, "Mailer.Send", trace.WithAttributes(
+		attribute.String("mail.template", templateFile),
+		attribute.String("mail.locale", locale),
+	))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	set, err := m.template(locale, templateFile)
 	if err != nil {
 		return err
 	}
 
-	plainBody := new(bytes.Buffer)
-	err = textTmpl.ExecuteTemplate(plainBody, "plainBody", data)
+	subject := new(bytes.Buffer)
+	err = set.text.ExecuteTemplate(subject, "subject", data)
 	if err != nil {
 		return err
 	}
 
-	htmlTmpl, err := ht.New("").ParseFS(templateFS, "templates/"+templateFile)
+	plainBody := new(bytes.Buffer)
+	err = set.text.ExecuteTemplate(plainBody, "plainBody", data)
 	if err != nil {
 		return err
 	}
 
 	htmlBody := new(bytes.Buffer)
-	err = htmlTmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
+	err = set.html.ExecuteTemplate(htmlBody, "htmlBody", data)
 	if err != nil {
 		return err
 	}
@@ -79,5 +165,8 @@ func (m *Mailer) Send(recipient string, templateFile string, data any) error {
 	msg.SetBodyString(mail.TypeTextPlain, plainBody.String())
 	msg.AddAlternativeString(mail.TypeTextHTML, htmlBody.String())
 
-	return m.client.DialAndSend(msg)
+	_, transportSpan := tracer.Start(ctx, "Transport.Send", trace.WithSpanKind(trace.SpanKindClient))
+	defer transportSpan.End()
+
+	return m.transport.Send(msg)
 }
@@ -0,0 +1,167 @@
+package mailer
+
+import (
+	"crypto/rand"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/wneessen/go-mail"
+)
+
+const (
+	TLSNone     = "none"
+	TLSStartTLS = "starttls"
+	TLSImplicit = "tls"
+)
+
+// Transport delivers composed messages, Mailer renders the templates and leaves the delivery to it.
+type Transport interface {
+	Send(msg *mail.Msg) error
+}
+
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	// TLS is TLSNone, TLSStartTLS or TLSImplicit: plain text, upgrade with STARTTLS or TLS from the first byte.
+	TLS string
+}
+
+type SMTPTransport struct {
+	client *mail.Client
+}
+
+// NewSMTPTransport connects to the server on every send. The credentials are only used
+// when a username is set, the authentication mechanism is negotiated with the server.
+func NewSMTPTransport(cfg SMTPConfig) (*SMTPTransport, error) {
+	options := []mail.Option{
+		mail.WithPort(cfg.Port),
+		mail.WithTimeout(5 * time.Second),
+	}
+
+	switch cfg.TLS {
+	case TLSNone:
+		options = append(options, mail.WithTLSPolicy(mail.NoTLS))
+	case TLSStartTLS:
+		options = append(options, mail.WithTLSPolicy(mail.TLSMandatory))
+	case TLSImplicit:
+		options = append(options, mail.WithSSL())
+	default:
+		return nil, fmt.Errorf("mailer: unknown TLS mode %q", cfg.TLS)
+	}
+
+	if cfg.Username != "" {
+		options = append(options,
+			mail.WithSMTPAuth(mail.SMTPAuthAutoDiscover),
+			mail.WithUsername(cfg.Username),
+			mail.WithPassword(cfg.Password),
+		)
+	}
+
+	client, err := mail.NewClient(cfg.Host, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SMTPTransport{client: client}, nil
+}
+
+func (t *SMTPTransport) Send(msg *mail.Msg) error {
+	return t.client.DialAndSend(msg)
+}
+
+// MaildirTransport writes every message as a file into the new directory of a maildir,
+// so that development setups can read the emails with any mail client.
+type MaildirTransport struct {
+	dir string
+}
+
+func NewMaildirTransport(dir string) (*MaildirTransport, error) {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		err := os.MkdirAll(filepath.Join(dir, sub), 0o755)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &MaildirTransport{dir: dir}, nil
+}
+
+// Send writes the message into tmp first and moves it into new, readers never see partial files.
+func (t *MaildirTransport) Send(msg *mail.Msg) error {
+	name := fmt.Sprintf("%d.%s.cyclingmarket.eml", time.Now().UnixNano(), rand.Text())
+	tmp := filepath.Join(t.dir, "tmp", name)
+
+	err := msg.WriteToFile(tmp)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmp, filepath.Join(t.dir, "new", name))
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return nil
+}
+
+// LogTransport does not deliver anything, it only logs the recipients and the subject.
+type LogTransport struct {
+	logger *slog.Logger
+}
+
+func NewLogTransport(logger *slog.Logger) *LogTransport {
+	return &LogTransport{logger: logger}
+}
+
+func (t *LogTransport) Send(msg *mail.Msg) error {
+	t.logger.Info("email not sent, log transport", "to", msg.GetToString(), "subject", msg.GetGenHeader(mail.HeaderSubject))
+	return nil
+}
+
+// maxMemoryMessages is how many messages MemoryTransport keeps, older ones are dropped.
+const maxMemoryMessages = 1000
+
+// MemoryTransport keeps the last maxMemoryMessages messages in memory, for tests that assert on
+// the sent emails.
+type MemoryTransport struct {
+	mu       sync.Mutex
+	messages []*mail.Msg
+}
+
+func NewMemoryTransport() *MemoryTransport {
+	return &MemoryTransport{}
+}
+
+func (t *MemoryTransport) Send(msg *mail.Msg) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.messages) >= maxMemoryMessages {
+		t.messages = slices.Delete(t.messages, 0, len(t.messages)-maxMemoryMessages+1)
+	}
+	t.messages = append(t.messages, msg)
+	return nil
+}
+
+// Messages returns the messages sent so far, oldest first.
+func (t *MemoryTransport) Messages() []*mail.Msg {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return append([]*mail.Msg(nil), t.messages...)
+}
+
+func (t *MemoryTransport) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.messages = nil
+}
@@ -1,21 +1,82 @@
 package validator
 
 import (
+	"net/url"
 	"regexp"
 	"slices"
+	"strings"
 )
 
 var (
 	EmailRX = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
 )
 
+// Message is a validation error: a stable code clients can match on and the arguments
+// its localized text is formatted with.
+type Message struct {
+	Code string
+	Args []any
+}
+
+func NewMessage(code string, args ...any) Message {
+	return Message{Code: code, Args: args}
+}
+
+var (
+	Required     = NewMessage("required")
+	Positive     = NewMessage("positive")
+	InvalidValue = NewMessage("invalid_value")
+	NoDuplicates = NewMessage("no_duplicates")
+	InvalidEmail = NewMessage("invalid_email")
+	NotInteger   = NewMessage("not_integer")
+	NotBoolean   = NewMessage("not_boolean")
+	NotTimestamp = NewMessage("not_timestamp")
+	ReadOnly     = NewMessage("read_only")
+)
+
+func MinBytes(n int) Message {
+	return NewMessage("min_bytes", n)
+}
+
+func MaxBytes(n int) Message {
+	return NewMessage("max_bytes", n)
+}
+
+func ExactBytes(n int) Message {
+	return NewMessage("exact_bytes", n)
+}
+
+func MinChars(n int) Message {
+	return NewMessage("min_chars", n)
+}
+
+func MaxValue(n int) Message {
+	return NewMessage("max_value", n)
+}
+
+func MinItems(n int) Message {
+	return NewMessage("min_items", n)
+}
+
+func MaxItems(n int) Message {
+	return NewMessage("max_items", n)
+}
+
+func OneOf(values ...string) Message {
+	return NewMessage("one_of", strings.Join(values, ", "))
+}
+
+func OnlyOf(values ...string) Message {
+	return NewMessage("only_of", strings.Join(values, ", "))
+}
+
 type Validator struct {
-	Errors map[string]string
+	Errors map[string]Message
 }
 
 func New() *Validator {
 	return &Validator{
-		Errors: make(map[string]string),
+		Errors: make(map[string]Message),
 	}
 }
 
@@ -23,13 +84,13 @@ func (v *Validator) Valid() bool {
 	return len(v.Errors) == 0
 }
 
-func (v *Validator) AddError(key, message string) {
+func (v *Validator) AddError(key string, message Message) {
 	if _, exists := v.Errors[key]; !exists {
 		v.Errors[key] = message
 	}
 }
 
-func (v *Validator) Check(ok bool, key, message string) {
+func (v *Validator) Check(ok bool, key string, message Message) {
 	if !ok {
 		v.AddError(key, message)
 	}
@@ -39,10 +100,26 @@ func PermittedValue[T comparable](value T, permittedValues ...T) bool {
 	return slices.Contains(permittedValues, value)
 }
 
+func PermittedValues[T comparable](values []T, permittedValues ...T) bool {
+	for _, value := range values {
+		if !slices.Contains(permittedValues, value) {
+			return false
+		}
+	}
+	return true
+}
+
 func Matches(value string, rx *regexp.Regexp) bool {
 	return rx.MatchString(value)
 }
 
+// HTTPURL reports whether value is an absolute http or https URL, other schemes like
+// javascript: must not end up in links.
+func HTTPURL(value string) bool {
+	u, err := url.Parse(value)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
 func Unique[T comparable](values []T) bool {
 	uniqueValues := make(map[T]bool)
 
@@ -0,0 +1,31 @@
+package validator
+
+import "testing"
+
+func TestHTTPURL(t *testing.T) {
+	tests := []struct {
+		value string
+		want  bool
+	}{
+		{"https://example.com/logo.png", true},
+		{"http://cdn.example.com:8080/a/b.jpg?v=2", true},
+		{"HTTPS://EXAMPLE.COM/logo.png", true},
+		{"", false},
+		{"javascript:alert(1)", false},
+		{"JavaScript:alert(document.cookie)", false},
+		{"data:image/svg+xml;base64,PHN2Zz48L3N2Zz4=", false},
+		{"vbscript:msgbox(1)", false},
+		{"file:///etc/passwd", false},
+		{"ftp://example.com/logo.png", false},
+		{"//example.com/logo.png", false},
+		{"/logo.png", false},
+		{"https:///logo.png", false},
+		{"http://exa mple.com", false},
+	}
+
+	for _, tt := range tests {
+		if got := HTTPURL(tt.value); got != tt.want {
+			t.Errorf("HTTPURL(%q) = %t, want %t", tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,4 @@
+delete from permissions where code = 'stolen:import';
+drop table if exists stolen_bikes;
+drop index if exists ads_frame_serial_idx;
+alter table ads drop column if exists frame_serial;
@@ -0,0 +1,15 @@
+alter table ads add column if not exists frame_serial text not null default '';
+create index if not exists ads_frame_serial_idx on ads (frame_serial) where frame_serial <> '';
+
+create table if not exists stolen_bikes (
+    id bigserial primary key,
+    created_at timestamp(0) with time zone not null default now(),
+    serial text unique not null,
+    description text not null default '',
+    source text not null,
+    reported_by bigint references users on delete set null
+);
+
+insert into permissions (code)
+values
+    ('stolen:import');
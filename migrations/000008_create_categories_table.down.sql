@@ -0,0 +1,2 @@
+-- the original free-form values of ads.categories are not restored, the slugs are kept as plain strings.
+drop table if exists categories;
@@ -0,0 +1,125 @@
+create table if not exists categories (
+    id bigserial primary key,
+    parent_id bigint references categories on delete cascade,
+    slug text unique not null,
+    names jsonb not null default '{}'
+);
+
+create index if not exists categories_parent_id_idx on categories (parent_id);
+
+insert into categories (parent_id, slug, names)
+values
+    (null, 'bikes', '{"en": "Bikes", "ru": "Велосипеды"}'),
+    (null, 'components', '{"en": "Components", "ru": "Компоненты"}'),
+    (null, 'apparel', '{"en": "Apparel", "ru": "Экипировка"}'),
+    (null, 'accessories', '{"en": "Accessories", "ru": "Аксессуары"}'),
+    (null, 'other', '{"en": "Other", "ru": "Другое"}');
+
+insert into categories (parent_id, slug, names)
+select p.id, v.slug, v.names::jsonb
+from (values
+    ('bikes', 'road', '{"en": "Road", "ru": "Шоссейные"}'),
+    ('bikes', 'gravel', '{"en": "Gravel", "ru": "Гревел"}'),
+    ('bikes', 'mtb', '{"en": "Mountain", "ru": "Горные"}'),
+    ('bikes', 'city', '{"en": "City", "ru": "Городские"}'),
+    ('bikes', 'kids', '{"en": "Kids", "ru": "Детские"}'),
+    ('bikes', 'bmx', '{"en": "BMX", "ru": "BMX"}'),
+    ('bikes', 'e-bikes', '{"en": "E-bikes", "ru": "Электровелосипеды"}'),
+    ('components', 'drivetrain', '{"en": "Drivetrain", "ru": "Трансмиссия"}'),
+    ('components', 'brakes', '{"en": "Brakes", "ru": "Тормоза"}'),
+    ('components', 'wheels', '{"en": "Wheels", "ru": "Колёса"}'),
+    ('components', 'tyres', '{"en": "Tyres", "ru": "Покрышки"}'),
+    ('components', 'frames', '{"en": "Frames", "ru": "Рамы"}'),
+    ('components', 'forks', '{"en": "Forks", "ru": "Вилки"}'),
+    ('components', 'cockpit', '{"en": "Cockpit", "ru": "Руль и вынос"}'),
+    ('components', 'saddles', '{"en": "Saddles", "ru": "Сёдла"}'),
+    ('components', 'pedals', '{"en": "Pedals", "ru": "Педали"}'),
+    ('apparel', 'helmets', '{"en": "Helmets", "ru": "Шлемы"}'),
+    ('apparel', 'shoes', '{"en": "Shoes", "ru": "Обувь"}'),
+    ('apparel', 'clothing', '{"en": "Clothing", "ru": "Одежда"}'),
+    ('accessories', 'lights', '{"en": "Lights", "ru": "Фонари"}'),
+    ('accessories', 'locks', '{"en": "Locks", "ru": "Замки"}'),
+    ('accessories', 'pumps', '{"en": "Pumps", "ru": "Насосы"}'),
+    ('accessories', 'bags', '{"en": "Bags", "ru": "Сумки"}'),
+    ('accessories', 'computers', '{"en": "Bike computers", "ru": "Велокомпьютеры"}'),
+    ('accessories', 'tools', '{"en": "Tools", "ru": "Инструменты"}')
+) as v(parent, slug, names)
+inner join categories p on p.slug = v.parent;
+
+insert into categories (parent_id, slug, names)
+select p.id, v.slug, v.names::jsonb
+from (values
+    ('road', 'road-endurance', '{"en": "Endurance", "ru": "Эндуранс"}'),
+    ('road', 'road-aero', '{"en": "Aero", "ru": "Аэро"}'),
+    ('road', 'road-climbing', '{"en": "Climbing", "ru": "Горные шоссейные"}'),
+    ('road', 'road-tt', '{"en": "Time trial / Triathlon", "ru": "Разделка / Триатлон"}'),
+    ('mtb', 'mtb-hardtail', '{"en": "Hardtail", "ru": "Хардтейлы"}'),
+    ('mtb', 'mtb-full-suspension', '{"en": "Full suspension", "ru": "Двухподвесы"}'),
+    ('drivetrain', 'cassettes', '{"en": "Cassettes", "ru": "Кассеты"}'),
+    ('drivetrain', 'chains', '{"en": "Chains", "ru": "Цепи"}'),
+    ('drivetrain', 'cranksets', '{"en": "Cranksets", "ru": "Системы"}'),
+    ('drivetrain', 'derailleurs', '{"en": "Derailleurs", "ru": "Переключатели"}'),
+    ('drivetrain', 'shifters', '{"en": "Shifters", "ru": "Манетки"}')
+) as v(parent, slug, names)
+inner join categories p on p.slug = v.parent;
+
+-- existing free-form values are mapped onto slugs: by slug, by localized name or by a known alias;
+-- anything left unrecognized ends up in "other".
+create temporary table category_aliases (
+    alias text primary key,
+    slug text not null
+);
+
+insert into category_aliases (alias, slug)
+values
+    ('шоссе', 'road'),
+    ('шоссейник', 'road'),
+    ('шоссейный', 'road'),
+    ('мтб', 'mtb'),
+    ('горный', 'mtb'),
+    ('горник', 'mtb'),
+    ('mountain bike', 'mtb'),
+    ('гравийник', 'gravel'),
+    ('гревел', 'gravel'),
+    ('городской', 'city'),
+    ('детский', 'kids'),
+    ('электровелосипед', 'e-bikes'),
+    ('ebike', 'e-bikes'),
+    ('e-bike', 'e-bikes'),
+    ('велосипед', 'bikes'),
+    ('bike', 'bikes'),
+    ('запчасти', 'components'),
+    ('parts', 'components'),
+    ('колесо', 'wheels'),
+    ('wheelset', 'wheels'),
+    ('покрышка', 'tyres'),
+    ('tires', 'tyres'),
+    ('рама', 'frames'),
+    ('frame', 'frames'),
+    ('вилка', 'forks'),
+    ('fork', 'forks'),
+    ('кассета', 'cassettes'),
+    ('цепь', 'chains'),
+    ('шлем', 'helmets'),
+    ('helmet', 'helmets'),
+    ('одежда', 'clothing'),
+    ('фонарь', 'lights'),
+    ('замок', 'locks');
+
+update ads
+set categories = (
+    select coalesce(array_agg(distinct mapped.slug), '{other}')
+    from (
+        select coalesce(
+            (select c.slug from categories c where c.slug = lower(trim(raw.value))),
+            (select ca.slug from category_aliases ca where ca.alias = lower(trim(raw.value))),
+            (select min(c.slug) from categories c
+                where lower(c.names->>'en') = lower(trim(raw.value))
+                   or lower(c.names->>'ru') = lower(trim(raw.value))),
+            'other'
+        ) as slug
+        from unnest(ads.categories) as raw(value)
+    ) as mapped
+);
+
+drop table category_aliases;
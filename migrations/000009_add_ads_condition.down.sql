@@ -0,0 +1,3 @@
+drop index if exists ads_condition_idx;
+alter table ads drop constraint if exists ads_condition_check;
+alter table ads drop column if exists condition;
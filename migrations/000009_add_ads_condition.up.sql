@@ -0,0 +1,3 @@
+alter table ads add column if not exists condition text not null default 'used';
+alter table ads add constraint ads_condition_check check (condition in ('new', 'like-new', 'used', 'for-parts'));
+create index if not exists ads_condition_idx on ads (condition);
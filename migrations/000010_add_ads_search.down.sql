@@ -0,0 +1,4 @@
+create index if not exists ads_title_idx on ads using gin (to_tsvector('simple', title));
+drop index if exists ads_title_trgm_idx;
+drop index if exists ads_search_vector_idx;
+alter table ads drop column if exists search_vector;
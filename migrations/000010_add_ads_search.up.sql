@@ -0,0 +1,12 @@
+create extension if not exists pg_trgm;
+
+alter table ads add column if not exists search_vector tsvector generated always as (
+    setweight(to_tsvector('russian', title), 'A') ||
+    setweight(to_tsvector('english', title), 'A') ||
+    setweight(to_tsvector('russian', description), 'B') ||
+    setweight(to_tsvector('english', description), 'B')
+) stored;
+
+create index if not exists ads_search_vector_idx on ads using gin (search_vector);
+create index if not exists ads_title_trgm_idx on ads using gin (title gin_trgm_ops);
+drop index if exists ads_title_idx;
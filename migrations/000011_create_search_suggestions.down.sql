@@ -0,0 +1,2 @@
+drop table if exists search_queries;
+drop table if exists brands;
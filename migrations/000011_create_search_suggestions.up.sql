@@ -0,0 +1,22 @@
+create table if not exists brands (
+    id bigserial primary key,
+    name text unique not null
+);
+
+create index if not exists brands_name_trgm_idx on brands using gin (name gin_trgm_ops);
+
+insert into brands (name)
+values
+    ('Author'), ('Bianchi'), ('BMC'), ('Bombtrack'), ('Campagnolo'), ('Cannondale'), ('Canyon'),
+    ('Cervelo'), ('Colnago'), ('Cube'), ('DT Swiss'), ('Felt'), ('Focus'), ('Format'), ('Forward'),
+    ('Fox'), ('Fuji'), ('Giant'), ('GT'), ('Kona'), ('Lapierre'), ('Liv'), ('Look'), ('Mavic'),
+    ('Merida'), ('Orbea'), ('Pinarello'), ('RockShox'), ('Santa Cruz'), ('Schwinn'), ('Scott'),
+    ('Shimano'), ('Specialized'), ('SRAM'), ('Stels'), ('Stern'), ('Trek'), ('Wilier'), ('YT');
+
+create table if not exists search_queries (
+    query text primary key,
+    count bigint not null default 1,
+    last_searched_at timestamp(0) with time zone not null default now()
+);
+
+create index if not exists search_queries_query_prefix_idx on search_queries (query text_pattern_ops);
@@ -0,0 +1,2 @@
+alter table ads add column if not exists updated_at timestamp(0) with time zone not null default now();
+update ads set updated_at = created_at;
@@ -0,0 +1,3 @@
+drop table if exists ad_images;
+drop index if exists ads_user_id_idx;
+alter table ads drop column if exists user_id;
@@ -0,0 +1,11 @@
+alter table ads add column if not exists user_id bigint references users on delete cascade;
+create index if not exists ads_user_id_idx on ads (user_id);
+
+create table if not exists ad_images (
+    id bigserial primary key,
+    ad_id bigint not null references ads on delete cascade,
+    url text not null,
+    position integer not null default 0
+);
+
+create index if not exists ad_images_ad_id_idx on ad_images (ad_id, position);
@@ -0,0 +1,12 @@
+create table if not exists idempotency_keys (
+    user_id bigint not null,
+    key text not null,
+    fingerprint bytea not null,
+    status integer,
+    headers jsonb not null default '{}',
+    body bytea,
+    created_at timestamp(0) with time zone not null default now(),
+    primary key (user_id, key)
+);
+
+create index if not exists idempotency_keys_created_at_idx on idempotency_keys (created_at);
@@ -0,0 +1,14 @@
+create table if not exists ad_imports (
+    id bigserial primary key,
+    created_at timestamp(0) with time zone not null default now(),
+    finished_at timestamp(0) with time zone,
+    user_id bigint not null references users on delete cascade,
+    format text not null,
+    atomic bool not null default false,
+    status text not null default 'pending',
+    total_rows integer not null default 0,
+    imported_rows integer not null default 0,
+    errors jsonb not null default '[]'
+);
+
+create index if not exists ad_imports_user_id_idx on ad_imports (user_id);
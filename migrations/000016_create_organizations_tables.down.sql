@@ -0,0 +1,4 @@
+drop index if exists ads_organization_id_idx;
+alter table ads drop column if exists organization_id;
+drop table if exists organization_members;
+drop table if exists organizations;
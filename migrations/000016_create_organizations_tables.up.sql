@@ -0,0 +1,24 @@
+create table if not exists organizations (
+    id bigserial primary key,
+    created_at timestamp(0) with time zone not null default now(),
+    name text not null,
+    slug text unique not null,
+    description text not null default '',
+    logo_url text not null default '',
+    opening_hours jsonb not null default '{}',
+    version integer not null default 1
+);
+
+create table if not exists organization_members (
+    organization_id bigint not null references organizations on delete cascade,
+    user_id bigint not null references users on delete cascade,
+    role text not null,
+    created_at timestamp(0) with time zone not null default now(),
+    primary key (organization_id, user_id),
+    constraint organization_members_role_check check (role in ('owner', 'manager', 'staff'))
+);
+
+create index if not exists organization_members_user_id_idx on organization_members (user_id);
+
+alter table ads add column if not exists organization_id bigint references organizations on delete set null;
+create index if not exists ads_organization_id_idx on ads (organization_id);
@@ -0,0 +1,3 @@
+drop index if exists ads_expires_at_idx;
+alter table ads drop column if exists renewal_reminder_sent_at;
+alter table ads drop column if exists expires_at;
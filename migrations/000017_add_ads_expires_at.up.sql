@@ -0,0 +1,7 @@
+alter table ads add column if not exists expires_at timestamp(0) with time zone not null default now() + interval '60 days';
+alter table ads add column if not exists renewal_reminder_sent_at timestamp(0) with time zone;
+
+-- existing ads get the usual lifetime, but at least a week so their owners can still be reminded
+update ads set expires_at = greatest(created_at + interval '60 days', now() + interval '7 days');
+
+create index if not exists ads_expires_at_idx on ads (expires_at);
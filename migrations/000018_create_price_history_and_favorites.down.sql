@@ -0,0 +1,3 @@
+drop table if exists favorites;
+alter table ads drop column if exists previous_price;
+drop table if exists ad_price_history;
@@ -0,0 +1,25 @@
+create table if not exists ad_price_history (
+    id bigserial primary key,
+    ad_id bigint not null references ads on delete cascade,
+    price integer not null,
+    changed_at timestamp(0) with time zone not null default now()
+);
+
+create index if not exists ad_price_history_ad_id_idx on ad_price_history (ad_id, changed_at);
+
+insert into ad_price_history (ad_id, price, changed_at)
+select id, price, created_at from ads;
+
+alter table ads add column if not exists previous_price integer;
+
+create table if not exists favorites (
+    user_id bigint not null references users on delete cascade,
+    ad_id bigint not null references ads on delete cascade,
+    price_drop_threshold integer not null default 10,
+    reference_price integer not null,
+    created_at timestamp(0) with time zone not null default now(),
+    primary key (user_id, ad_id),
+    constraint favorites_price_drop_threshold_check check (price_drop_threshold between 1 and 100)
+);
+
+create index if not exists favorites_ad_id_idx on favorites (ad_id);
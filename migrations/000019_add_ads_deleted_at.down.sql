@@ -0,0 +1,3 @@
+drop index if exists ads_deleted_at_idx;
+delete from ads where deleted_at is not null;
+alter table ads drop column if exists deleted_at;
@@ -0,0 +1,3 @@
+alter table ads add column if not exists deleted_at timestamp(0) with time zone;
+
+create index if not exists ads_deleted_at_idx on ads (deleted_at) where deleted_at is not null;
@@ -0,0 +1,2 @@
+delete from permissions where code = 'audit:read';
+drop table if exists audit_log;
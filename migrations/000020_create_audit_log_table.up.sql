@@ -0,0 +1,23 @@
+create table if not exists audit_log (
+    id bigserial primary key,
+    created_at timestamp(0) with time zone not null default now(),
+    actor_id bigint,
+    ip text not null default '',
+    action text not null,
+    entity text not null,
+    entity_id bigint not null,
+    changes jsonb not null default '{}',
+    request_id text not null default ''
+);
+
+create index if not exists audit_log_actor_id_idx on audit_log (actor_id, created_at);
+create index if not exists audit_log_entity_idx on audit_log (entity, entity_id, created_at);
+create index if not exists audit_log_created_at_idx on audit_log (created_at);
+
+-- the log is append-only, entries can not be changed or removed
+create or replace rule audit_log_no_update as on update to audit_log do instead nothing;
+create or replace rule audit_log_no_delete as on delete to audit_log do instead nothing;
+
+insert into permissions (code)
+values
+    ('audit:read');
@@ -0,0 +1,2 @@
+delete from permissions where code = 'outbox:manage';
+drop table if exists outbox_messages;
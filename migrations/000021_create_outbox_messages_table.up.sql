@@ -0,0 +1,20 @@
+create table if not exists outbox_messages (
+    id bigserial primary key,
+    created_at timestamp(0) with time zone not null default now(),
+    recipient text not null,
+    template text not null,
+    data jsonb not null default '{}',
+    status text not null default 'pending',
+    attempts integer not null default 0,
+    next_attempt_at timestamp(0) with time zone not null default now(),
+    last_error text not null default '',
+    sent_at timestamp(0) with time zone,
+    constraint outbox_messages_status_check check (status in ('pending', 'sent', 'dead'))
+);
+
+create index if not exists outbox_messages_due_idx on outbox_messages (next_attempt_at) where status = 'pending';
+create index if not exists outbox_messages_status_idx on outbox_messages (status, created_at);
+
+insert into permissions (code)
+values
+    ('outbox:manage');
@@ -0,0 +1,4 @@
+alter table outbox_messages drop column if exists locale;
+
+alter table users drop constraint if exists users_locale_check;
+alter table users drop column if exists locale;
@@ -0,0 +1,4 @@
+alter table users add column if not exists locale text not null default 'en';
+alter table users add constraint users_locale_check check (locale in ('ru', 'en'));
+
+alter table outbox_messages add column if not exists locale text not null default 'en';
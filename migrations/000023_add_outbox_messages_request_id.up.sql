@@ -0,0 +1 @@
+alter table outbox_messages add column if not exists request_id text not null default '';
@@ -0,0 +1 @@
+alter table outbox_messages drop column if exists locked_until;
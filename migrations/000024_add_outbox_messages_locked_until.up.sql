@@ -0,0 +1 @@
+alter table outbox_messages add column if not exists locked_until timestamp(0) with time zone;
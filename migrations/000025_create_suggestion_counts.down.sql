@@ -0,0 +1,3 @@
+drop materialized view if exists category_ad_counts;
+drop materialized view if exists model_ad_counts;
+drop materialized view if exists brand_ad_counts;
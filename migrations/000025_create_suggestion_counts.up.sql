@@ -0,0 +1,31 @@
+-- suggestions are served from these views, so that the type-ahead endpoint never scans ads,
+-- they only count listed ads and are refreshed periodically by the API
+
+create materialized view if not exists brand_ad_counts as
+select brands.name, count(ads.id) as count
+from brands
+left join ads on ads.title ilike '%' || brands.name || '%' and ads.deleted_at is null and ads.expires_at > now()
+group by brands.name;
+
+create unique index if not exists brand_ad_counts_name_idx on brand_ad_counts (name);
+create index if not exists brand_ad_counts_name_trgm_idx on brand_ad_counts using gin (name gin_trgm_ops);
+
+-- a model is a brand followed by the next word of the title, "Trek Marlin 7 29er" is a Trek Marlin
+create materialized view if not exists model_ad_counts as
+select min(brands.name || ' ' || parts[1]) as name, count(*) as count
+from ads
+inner join brands on ads.title ilike '%' || brands.name || '%'
+cross join lateral regexp_match(ads.title, '\m' || brands.name || '\s+([[:alnum:]][[:alnum:]-]*)', 'i') as model(parts)
+where parts is not null and ads.deleted_at is null and ads.expires_at > now()
+group by lower(brands.name || ' ' || parts[1]);
+
+create unique index if not exists model_ad_counts_name_idx on model_ad_counts (name);
+create index if not exists model_ad_counts_name_trgm_idx on model_ad_counts using gin (name gin_trgm_ops);
+
+create materialized view if not exists category_ad_counts as
+select categories.slug, count(ads.id) as count
+from categories
+left join ads on ads.categories @> array[categories.slug] and ads.deleted_at is null and ads.expires_at > now()
+group by categories.slug;
+
+create unique index if not exists category_ad_counts_slug_idx on category_ad_counts (slug);
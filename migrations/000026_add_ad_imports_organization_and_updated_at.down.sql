@@ -0,0 +1,3 @@
+drop index if exists ad_imports_unfinished_idx;
+alter table ad_imports drop column if exists updated_at;
+alter table ad_imports drop column if exists organization_id;
@@ -0,0 +1,4 @@
+alter table ad_imports add column if not exists organization_id bigint references organizations on delete set null;
+alter table ad_imports add column if not exists updated_at timestamp(0) with time zone not null default now();
+
+create index if not exists ad_imports_unfinished_idx on ad_imports (updated_at) where status in ('pending', 'processing');
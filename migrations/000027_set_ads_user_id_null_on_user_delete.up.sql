@@ -0,0 +1,2 @@
+alter table ads drop constraint if exists ads_user_id_fkey;
+alter table ads add constraint ads_user_id_fkey foreign key (user_id) references users on delete set null;
@@ -0,0 +1,4 @@
+delete from idempotency_keys where client_ip <> '';
+alter table idempotency_keys drop constraint if exists idempotency_keys_pkey;
+alter table idempotency_keys add primary key (user_id, key);
+alter table idempotency_keys drop column if exists client_ip;
@@ -0,0 +1,3 @@
+alter table idempotency_keys add column if not exists client_ip text not null default '';
+alter table idempotency_keys drop constraint if exists idempotency_keys_pkey;
+alter table idempotency_keys add primary key (user_id, client_ip, key);
@@ -0,0 +1 @@
+delete from permissions where code = 'ads:moderate';
@@ -0,0 +1,3 @@
+insert into permissions (code)
+values
+    ('ads:moderate');
@@ -0,0 +1,2 @@
+drop trigger if exists users_soft_delete_ads on users;
+drop function if exists soft_delete_ads_of_deleted_user();
@@ -0,0 +1,15 @@
+-- private ads of a deleted user are soft-deleted and purged with the other deleted ads,
+-- ads of an organization stay listed and are managed by its members
+create or replace function soft_delete_ads_of_deleted_user() returns trigger as $$
+begin
+    update ads
+    set deleted_at = now(), updated_at = now(), version = version + 1
+    where user_id = old.id and organization_id is null and deleted_at is null;
+    return old;
+end;
+$$ language plpgsql;
+
+drop trigger if exists users_soft_delete_ads on users;
+create trigger users_soft_delete_ads
+    before delete on users
+    for each row execute function soft_delete_ads_of_deleted_user();
@@ -0,0 +1,3 @@
+drop table if exists search_query_searchers;
+drop index if exists search_queries_last_searched_at_idx;
+alter table search_queries drop column if exists searchers;
@@ -0,0 +1,13 @@
+alter table search_queries add column if not exists searchers bigint not null default 0;
+
+-- who searched for a query, as a hash of the user or the client IP, so that a query is only
+-- suggested once several people searched for it
+create table if not exists search_query_searchers (
+    query text not null references search_queries on delete cascade,
+    searcher bytea not null,
+    last_searched_at timestamp(0) with time zone not null default now(),
+    primary key (query, searcher)
+);
+
+create index if not exists search_queries_last_searched_at_idx on search_queries (last_searched_at);
+create index if not exists search_query_searchers_last_searched_at_idx on search_query_searchers (last_searched_at);
@@ -0,0 +1,10 @@
+delete from permissions where code = 'stolen:moderate';
+
+drop index if exists stolen_bikes_pending_idx;
+drop index if exists stolen_bikes_serial_idx;
+delete from stolen_bikes where status = 'rejected';
+alter table stolen_bikes add constraint stolen_bikes_serial_key unique (serial);
+
+alter table stolen_bikes drop column if exists reviewed_at;
+alter table stolen_bikes drop column if exists reviewed_by;
+alter table stolen_bikes drop column if exists status;
@@ -0,0 +1,16 @@
+-- reports of users only block listings once a moderator approved them, imported lists come
+-- from trusted sources and are approved right away
+alter table stolen_bikes add column if not exists status text not null default 'pending';
+alter table stolen_bikes add column if not exists reviewed_by bigint references users on delete set null;
+alter table stolen_bikes add column if not exists reviewed_at timestamp(0) with time zone;
+
+update stolen_bikes set status = 'approved' where source = 'import';
+
+-- a rejected report must not keep the serial from being reported again
+alter table stolen_bikes drop constraint if exists stolen_bikes_serial_key;
+create unique index if not exists stolen_bikes_serial_idx on stolen_bikes (serial) where status <> 'rejected';
+create index if not exists stolen_bikes_pending_idx on stolen_bikes (created_at) where status = 'pending';
+
+insert into permissions (code)
+values
+    ('stolen:moderate');